@@ -0,0 +1,298 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// completionFlag is a flag offered by the completion scripts
+type completionFlag struct {
+	name     string
+	usage    string
+	hasValue bool
+}
+
+// completionData is what the completion scripts are generated from
+type completionData struct {
+	topWords    []string            // First words of the commands, in table order
+	groups      map[string][]string // Subcommands of multi-word commands, e.g. "send": text, file
+	global      []completionFlag
+	flags       map[string][]completionFlag // Command flags without the global ones, by command name
+	valueFlags  []string                    // Every flag that takes a value, as "--name"
+	summaryOf   map[string]string
+	choicesOf   map[string][]string
+	fileArgsFor map[string]bool // Commands whose arguments include paths
+}
+
+// completionScript returns the completion script for shell
+func completionScript(shell string) (string, error) {
+	data := collectCompletion()
+	switch shell {
+	case "bash":
+		return bashCompletion(data), nil
+	case "zsh":
+		return zshCompletion(data), nil
+	case "fish":
+		return fishCompletion(data), nil
+	default:
+		return "", usageErrorf("unsupported shell %q (use bash, zsh or fish)", shell)
+	}
+}
+
+func collectCompletion() *completionData {
+	data := &completionData{
+		groups:      make(map[string][]string),
+		flags:       make(map[string][]completionFlag),
+		summaryOf:   make(map[string]string),
+		choicesOf:   make(map[string][]string),
+		fileArgsFor: make(map[string]bool),
+	}
+
+	globals := flag.NewFlagSet("", flag.ContinueOnError)
+	new(globalOptions).register(globals)
+	data.global = completionFlags(globals, nil)
+	isGlobal := make(map[string]bool)
+	for _, f := range data.global {
+		isGlobal[f.name] = true
+	}
+
+	valueFlags := make(map[string]bool)
+	for _, f := range data.global {
+		if f.hasValue {
+			valueFlags["--"+f.name] = true
+		}
+	}
+
+	for i := range commands {
+		cmd := &commands[i]
+		words := strings.Fields(cmd.name)
+		if !contains(data.topWords, words[0]) {
+			data.topWords = append(data.topWords, words[0])
+		}
+		if len(words) > 1 {
+			data.groups[words[0]] = append(data.groups[words[0]], words[1])
+		}
+
+		data.flags[cmd.name] = completionFlags(commandFlags(cmd), isGlobal)
+		for _, f := range data.flags[cmd.name] {
+			if f.hasValue {
+				valueFlags["--"+f.name] = true
+			}
+		}
+		data.summaryOf[cmd.name] = cmd.summary
+		data.choicesOf[cmd.name] = cmd.choices
+		for _, arg := range cmd.args {
+			if strings.Contains(arg, "path") || strings.Contains(arg, "dir") {
+				data.fileArgsFor[cmd.name] = true
+			}
+		}
+	}
+
+	for name := range valueFlags {
+		data.valueFlags = append(data.valueFlags, name)
+	}
+	sort.Strings(data.valueFlags)
+	return data
+}
+
+// completionFlags lists the flags of set, leaving out those in skip
+func completionFlags(set *flag.FlagSet, skip map[string]bool) []completionFlag {
+	var flags []completionFlag
+	set.VisitAll(func(f *flag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		_, usage := flag.UnquoteUsage(f)
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:     f.Name,
+			usage:    usage,
+			hasValue: !ok || !boolFlag.IsBoolFlag(),
+		})
+	})
+	return flags
+}
+
+// flagNames returns "--name" for every flag
+func flagNames(flags ...[]completionFlag) string {
+	var names []string
+	for _, list := range flags {
+		for _, f := range list {
+			names = append(names, "--"+f.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// shellCases returns the case branches shared by bash and zsh, keyed by the command words typed so far
+func shellCases(data *completionData, assign func(choices, flags string) string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\t\"\") %s ;;\n", assign(strings.Join(data.topWords, " "), flagNames(data.global)))
+	for _, word := range data.topWords {
+		if sub := data.groups[word]; len(sub) > 0 {
+			// data.flags has no entry unless word is a command of its own, like "history"
+			fmt.Fprintf(&b, "\t%q) %s ;;\n", word, assign(strings.Join(sub, " "), flagNames(data.global, data.flags[word])))
+		}
+	}
+	// Longer command names first, so "history show" is matched before "history"
+	ordered := make([]command, len(commands))
+	copy(ordered, commands)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return len(strings.Fields(ordered[i].name)) > len(strings.Fields(ordered[j].name))
+	})
+	for _, cmd := range ordered {
+		flags := flagNames(data.global, data.flags[cmd.name])
+		if choices := data.choicesOf[cmd.name]; len(choices) > 0 {
+			fmt.Fprintf(&b, "\t%q) %s ;;\n", cmd.name, assign(strings.Join(choices, " "), flags))
+		}
+		fmt.Fprintf(&b, "\t%q*) %s ;;\n", cmd.name, assign("", flags))
+	}
+	return b.String()
+}
+
+func bashCompletion(data *completionData) string {
+	cases := shellCases(data, func(choices, flags string) string {
+		return fmt.Sprintf("choices=%q; flags=%q", choices, flags)
+	})
+	return `# bash completion for local-share
+# Load it with: source <(local-share completion bash)
+_local_share() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" cmdpath="" word i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		word="${COMP_WORDS[i]}"
+		case "$word" in
+		` + strings.Join(data.valueFlags, "|") + `) ((i++)); continue ;;
+		-*) continue ;;
+		esac
+		cmdpath="${cmdpath:+$cmdpath }$word"
+	done
+
+	local choices="" flags=""
+	case "$cmdpath" in
+` + cases + `	esac
+
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+	elif [[ -n $choices ]]; then
+		COMPREPLY=($(compgen -W "$choices" -- "$cur"))
+	fi
+}
+complete -o default -F _local_share local-share
+`
+}
+
+func zshCompletion(data *completionData) string {
+	cases := shellCases(data, func(choices, flags string) string {
+		return fmt.Sprintf("choices=(%s); flags=(%s)", choices, flags)
+	})
+	return `#compdef local-share
+# zsh completion for local-share
+# Load it with: source <(local-share completion zsh), or save it as _local-share in $fpath
+_local_share() {
+	local cmdpath="" word
+	local -i i
+	for ((i = 2; i < CURRENT; i++)); do
+		word="${words[i]}"
+		case "$word" in
+		` + strings.Join(data.valueFlags, "|") + `) ((i++)); continue ;;
+		-*) continue ;;
+		esac
+		cmdpath="${cmdpath:+$cmdpath }$word"
+	done
+
+	local -a choices flags
+	case "$cmdpath" in
+` + cases + `	esac
+
+	if [[ ${words[CURRENT]} == -* ]]; then
+		compadd -- $flags
+	elif (( ${#choices} )); then
+		compadd -- $choices
+	else
+		_files
+	fi
+}
+
+if [ "$funcstack[1]" = "_local_share" ]; then
+	_local_share "$@"
+else
+	compdef _local_share local-share
+fi
+`
+}
+
+func fishCompletion(data *completionData) string {
+	var b strings.Builder
+	b.WriteString("# fish completion for local-share\n")
+	b.WriteString("# Load it with: local-share completion fish | source\n")
+	b.WriteString("complete -c local-share -f\n")
+
+	for _, f := range data.global {
+		b.WriteString(fishFlag("", f))
+	}
+
+	for _, word := range data.topWords {
+		summary := data.summaryOf[word]
+		if sub := data.groups[word]; len(sub) > 0 {
+			summary = word + " " + strings.Join(sub, "|")
+		}
+		fmt.Fprintf(&b, "complete -c local-share -n __fish_use_subcommand -a %s -d %s\n", word, fishQuote(summary))
+		if sub := data.groups[word]; len(sub) > 0 {
+			for _, name := range sub {
+				condition := fmt.Sprintf("__fish_seen_subcommand_from %s; and not __fish_seen_subcommand_from %s", word, strings.Join(sub, " "))
+				fmt.Fprintf(&b, "complete -c local-share -n %s -a %s -d %s\n",
+					fishQuote(condition), name, fishQuote(data.summaryOf[word+" "+name]))
+			}
+		}
+	}
+
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		conditions := make([]string, len(words))
+		for i, word := range words {
+			conditions[i] = "__fish_seen_subcommand_from " + word
+		}
+		condition := strings.Join(conditions, "; and ")
+
+		for _, f := range data.flags[cmd.name] {
+			b.WriteString(fishFlag(condition, f))
+		}
+		if choices := data.choicesOf[cmd.name]; len(choices) > 0 {
+			fmt.Fprintf(&b, "complete -c local-share -n %s -a %s\n", fishQuote(condition), fishQuote(strings.Join(choices, " ")))
+		}
+		if data.fileArgsFor[cmd.name] {
+			fmt.Fprintf(&b, "complete -c local-share -n %s -F\n", fishQuote(condition))
+		}
+	}
+	return b.String()
+}
+
+// fishFlag returns the fish completion line for one flag, limited by condition if set
+func fishFlag(condition string, f completionFlag) string {
+	line := "complete -c local-share"
+	if condition != "" {
+		line += " -n " + fishQuote(condition)
+	}
+	line += " -l " + f.name
+	if f.hasValue {
+		line += " -r"
+	}
+	return line + " -d " + fishQuote(f.usage) + "\n"
+}
+
+// fishQuote quotes s as a fish single-quoted string
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"local-share/pkg/receiver"
+	"local-share/pkg/sender"
+)
+
+// Exit codes, documented in the README
+const (
+	EXIT_OK           = 0
+	EXIT_FAILURE      = 1 // Any other failure: protocol error, checksum mismatch, interrupted transfer
+	EXIT_USAGE        = 2 // Invalid command, arguments or flags (also used by the flag package)
+	EXIT_CONNECT      = 3 // The receiver could not be reached, or the receiver could not listen
+	EXIT_AUTH         = 4 // Missing or weak password, or the receiver uses a different one
+	EXIT_REJECTED     = 5 // The receiver refused the transfer
+	EXIT_LOCAL_IO     = 6 // A local file or directory could not be read or written
+	EXIT_INCOMPATIBLE = 7 // The receiver speaks no protocol version, cipher or compression this sender does
+)
+
+// exitCodes maps error kinds to exit codes, first match wins
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{sender.ErrNoKey, EXIT_AUTH},
+	{sender.ErrConnect, EXIT_CONNECT},
+	{sender.ErrAuth, EXIT_AUTH},
+	{sender.ErrRejected, EXIT_REJECTED},
+	{sender.ErrLocalIO, EXIT_LOCAL_IO},
+	{sender.ErrIncompatible, EXIT_INCOMPATIBLE},
+	{receiver.ErrNoKey, EXIT_AUTH},
+	{receiver.ErrConfig, EXIT_USAGE},
+	{receiver.ErrStorage, EXIT_LOCAL_IO},
+	{receiver.ErrListen, EXIT_CONNECT},
+}
+
+// usageError is an invalid command line
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// usageErrorf formats a usageError
+func usageErrorf(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// exitCode returns the exit code for the result of a command
+func exitCode(err error) int {
+	var usage *usageError
+	if err == nil {
+		return EXIT_OK
+	}
+	if errors.As(err, &usage) {
+		return EXIT_USAGE
+	}
+	for _, e := range exitCodes {
+		if errors.Is(err, e.kind) {
+			return e.code
+		}
+	}
+	return EXIT_FAILURE
+}
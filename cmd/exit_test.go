@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/protocol"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+	"local-share/pkg/sender"
+)
+
+const (
+	TEST_PASSWORD  = "Str0ng-Passw0rd!"
+	OTHER_PASSWORD = "Other-Passw0rd!x"
+	RUN_MAIN_ENV   = "LOCAL_SHARE_TEST_RUN_MAIN" // Makes the test binary behave like local-share
+)
+
+// TestMain lets the CLI tests run the test binary as local-share
+func TestMain(m *testing.M) {
+	if os.Getenv(RUN_MAIN_ENV) != "" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestExitCode(t *testing.T) {
+	senderError := func(kind error) error {
+		return &sender.Error{Op: "send file", Addr: "192.168.1.100:8080", Kind: kind, Err: errors.New("details")}
+	}
+	receiverError := func(kind error) error {
+		return fmt.Errorf("%w: %w", kind, errors.New("details"))
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, EXIT_OK},
+		{"usage", usageErrorf("wrong number of arguments"), EXIT_USAGE},
+		{"wrapped usage", fmt.Errorf("sync: %w", usageErrorf("bad flag")), EXIT_USAGE},
+		{"unknown error", errors.New("something broke"), EXIT_FAILURE},
+
+		{"sender no key", fmt.Errorf("%w: %w", sender.ErrNoKey, errors.New("password too short")), EXIT_AUTH},
+		{"sender connect", senderError(sender.ErrConnect), EXIT_CONNECT},
+		{"sender auth", senderError(sender.ErrAuth), EXIT_AUTH},
+		{"sender rejected", senderError(sender.ErrRejected), EXIT_REJECTED},
+		{"sender protocol", senderError(sender.ErrProtocol), EXIT_FAILURE},
+		{"sender checksum", senderError(sender.ErrChecksum), EXIT_FAILURE},
+		{"sender local I/O", senderError(sender.ErrLocalIO), EXIT_LOCAL_IO},
+		{"sender incompatible", senderError(sender.ErrIncompatible), EXIT_INCOMPATIBLE},
+		{"sender interrupted", senderError(context.Canceled), EXIT_FAILURE},
+		{"several targets", errors.Join(senderError(sender.ErrRejected), senderError(sender.ErrConnect)), EXIT_CONNECT},
+
+		{"receiver no key", receiverError(receiver.ErrNoKey), EXIT_AUTH},
+		{"receiver config", receiverError(receiver.ErrConfig), EXIT_USAGE},
+		{"receiver storage", receiverError(receiver.ErrStorage), EXIT_LOCAL_IO},
+		{"receiver listen", receiverError(receiver.ErrListen), EXIT_CONNECT},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := exitCode(test.err); got != test.want {
+				t.Errorf("exitCode(%v) = %d, want %d", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+// TestExitCodesCoverKinds makes sure every sender error kind has a deliberate exit code
+func TestExitCodesCoverKinds(t *testing.T) {
+	mapped := map[error]bool{}
+	for _, e := range exitCodes {
+		mapped[e.kind] = true
+	}
+	// Protocol and checksum errors are generic failures on purpose
+	for _, kind := range []error{sender.ErrNoKey, sender.ErrConnect, sender.ErrAuth, sender.ErrRejected, sender.ErrLocalIO, sender.ErrIncompatible,
+		receiver.ErrNoKey, receiver.ErrConfig, receiver.ErrStorage, receiver.ErrListen} {
+		if !mapped[kind] {
+			t.Errorf("%v has no exit code", kind)
+		}
+	}
+}
+
+// runCLI runs local-share with args and LOCALSHARE_KEY set to password, returning its exit code and output
+func runCLI(t *testing.T, password string, args ...string) (int, string) {
+	t.Helper()
+	home := t.TempDir()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = home
+	cmd.Env = append(os.Environ(),
+		RUN_MAIN_ENV+"=1",
+		"LOCALSHARE_KEY="+password,
+		"LOCALSHARE_CONFIG=",
+		"LOCALSHARE_PROFILE=",
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, "config"),
+		"XDG_DATA_HOME="+filepath.Join(home, "data"),
+	)
+	output, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatalf("running %v: %v", args, err)
+	}
+	return cmd.ProcessState.ExitCode(), string(output)
+}
+
+// unusedAddr returns a local address nothing listens on
+func unusedAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the command line")
+	}
+	addr := receivertest.Start(t, crypto.PadKey(TEST_PASSWORD), receiver.WithLimits(receiver.Limits{MaxFileSize: 8})).Addr
+	unlimited := receivertest.Start(t, crypto.PadKey(TEST_PASSWORD)).Addr
+	file := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(file, []byte("more than eight bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	_, busyPort, _ := net.SplitHostPort(busy.Addr().String())
+	notADir := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(notADir, nil, 0644)
+
+	tests := []struct {
+		name     string
+		password string
+		args     []string
+		want     int
+		output   string // Part of the output, if checked
+	}{
+		{"sent", TEST_PASSWORD, []string{"send", "text", addr, "hello"}, EXIT_OK, "sent successfully"},
+		{"unknown command", TEST_PASSWORD, []string{"fly"}, EXIT_USAGE, "Unknown command"},
+		{"missing argument", TEST_PASSWORD, []string{"send", "text", addr}, EXIT_USAGE, "Wrong number of arguments"},
+		{"nobody listening", TEST_PASSWORD, []string{"send", "text", unusedAddr(t), "hello"}, EXIT_CONNECT, "cannot connect"},
+		{"wrong password", OTHER_PASSWORD, []string{"send", "text", addr, "hello"}, EXIT_AUTH, "authentication failed"},
+		{"weak password", "short", []string{"send", "text", addr, "hello"}, EXIT_AUTH, "at least"},
+		{"file too large", TEST_PASSWORD, []string{"send", "file", addr, file}, EXIT_REJECTED, "file too large"},
+		{"missing file", TEST_PASSWORD, []string{"send", "file", addr, file + ".missing"}, EXIT_LOCAL_IO, ""},
+		{"JSON reject code", TEST_PASSWORD, []string{"send", "file", addr, file, "--json"}, EXIT_REJECTED, `"reject_code":"` + protocol.REJECT_LIMIT + `"`},
+		{"rejected by one of several", TEST_PASSWORD, []string{"send", "file", "--to", unlimited + "," + addr, file}, EXIT_REJECTED, "Sent report.txt to 1 of 2 receivers"},
+
+		{"receiver port in use", TEST_PASSWORD, []string{"receiver", "--port", busyPort}, EXIT_CONNECT, "Error starting server"},
+		{"receiver weak password", "short", []string{"receiver", "--port", busyPort}, EXIT_AUTH, ""},
+		{"receiver bad layout", TEST_PASSWORD, []string{"receiver", "--port", busyPort, "--layout", "{nope}/{name}"}, EXIT_USAGE, "unknown placeholder"},
+		{"receiver upload dir is a file", TEST_PASSWORD, []string{"receiver", "--port", busyPort, "--out", notADir}, EXIT_LOCAL_IO, "uploads directory"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, output := runCLI(t, test.password, test.args...)
+			if code != test.want {
+				t.Errorf("local-share %s exited with %d, want %d\n%s", strings.Join(test.args, " "), code, test.want, output)
+			}
+			if !strings.Contains(output, test.output) {
+				t.Errorf("local-share %s printed %q, want it to contain %q", strings.Join(test.args, " "), output, test.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"local-share/pkg/config"
+	"local-share/pkg/output"
+)
+
+// globalOptions holds the flags accepted by every command
+type globalOptions struct {
+	json       bool
+	level      string
+	format     string
+	configPath string
+	profile    string
+}
+
+// register adds the global flags to flags, keeping values parsed earlier as defaults
+func (o *globalOptions) register(flags *flag.FlagSet) {
+	if o.level == "" {
+		o.level, o.format = "info", "text"
+	}
+	flags.BoolVar(&o.json, "json", o.json, "print one JSON object per event or result")
+	flags.StringVar(&o.level, "log-level", o.level, "`level` of diagnostics to log: debug, info, warn or error")
+	flags.StringVar(&o.format, "log-format", o.format, "log `format`: text or json")
+	flags.StringVar(&o.configPath, "config", o.configPath, "config `file` (default ~/.config/local-share/config.toml, or $LOCALSHARE_CONFIG)")
+	flags.StringVar(&o.profile, "profile", o.profile, "config `profile` to use (or $LOCALSHARE_PROFILE)")
+}
+
+// settings loads the config file and profile, with the LOCALSHARE_* environment on top.
+// Flags set on the command line override the result.
+func (o *globalOptions) settings() (config.Settings, error) {
+	path := o.configPath
+	if path == "" {
+		var err error
+		if path, err = config.Path(); err != nil {
+			return config.Settings{}, err
+		}
+	}
+	file, err := config.Load(path)
+	if err != nil {
+		return config.Settings{}, usageErrorf("invalid config: %v", err)
+	}
+
+	profile := o.profile
+	if profile == "" {
+		profile = os.Getenv(config.ENV_PROFILE)
+	}
+	settings, err := file.Profile(profile)
+	if err != nil {
+		return config.Settings{}, usageErrorf("%v", err)
+	}
+	return settings.Merge(config.FromEnv()), nil
+}
+
+// printer returns where command results are printed
+func (o *globalOptions) printer() *output.Printer {
+	return output.New(os.Stdout, o.json)
+}
+
+// logger builds the structured logger writing to stderr
+func (o *globalOptions) logger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.level)); err != nil {
+		return nil, usageErrorf("invalid --log-level %q (use debug, info, warn or error)", o.level)
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	switch o.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, handlerOptions)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions)), nil
+	default:
+		return nil, usageErrorf("invalid --log-format %q (use text or json)", o.format)
+	}
+}
+
+// isSet reports whether the flag called name was given on the command line
+func isSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// firstSet returns the first non-empty value
+func firstSet(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// listFlag collects a flag that may be repeated or given as a comma-separated list
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, splitList(value)...)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// sizeFlag is a byte count that accepts units such as KB, MB and GB
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	// Show whole units so the default in the help reads like what a user would type
+	size := int64(*f)
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if size >= unit.bytes && size%unit.bytes == 0 {
+			return strconv.FormatInt(size/unit.bytes, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+func (f *sizeFlag) Set(value string) error {
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	*f = sizeFlag(size)
+	return nil
+}
+
+// rateFlag is a bandwidth in bytes per second such as 10MB/s; 0 means no limit
+type rateFlag int64
+
+func (f *rateFlag) String() string {
+	if *f == 0 {
+		return ""
+	}
+	size := sizeFlag(*f)
+	return size.String() + "/s"
+}
+
+func (f *rateFlag) Set(value string) error {
+	rate, err := parseSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil {
+		return fmt.Errorf("invalid rate %q (e.g. 500KB/s, 10MB/s)", value)
+	}
+	*f = rateFlag(rate)
+	return nil
+}
+
+// parseSize parses sizes like "1024", "64KB", "10MB" or "4GB" (1KB = 1024 bytes)
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.multiplier
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	// The negated comparison also refuses NaN
+	if err != nil || !(number >= 0) {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	// Larger values do not fit in int64 and would wrap around
+	size := number * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", value)
+	}
+	return int64(size), nil
+}
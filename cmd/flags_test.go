@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr string // Part of the error, empty if parsing succeeds
+	}{
+		{"0", 0, ""},
+		{"1024", 1024, ""},
+		{" 64kb ", 64 << 10, ""},
+		{"10MB", 10 << 20, ""},
+		{"1.5G", 3 << 29, ""},
+		{"4 TB", 4 << 40, ""},
+		{"12B", 12, ""},
+		{"8388607TB", 8388607 << 40, ""}, // Largest whole number of terabytes that fits
+		{"8388608TB", 0, "too large"},
+		{"9223372036854775807", 0, "too large"}, // Rounds up to 2^63 as a float
+		{"9223372036854774784", 9223372036854774784, ""},
+		{"1e300", 0, "too large"},
+		{"1e300TB", 0, "too large"},
+		{"Inf", 0, "too large"},
+		{"NaN", 0, "invalid size"},
+		{"-1", 0, "invalid size"},
+		{"-Inf", 0, "invalid size"},
+		{"", 0, "invalid size"},
+		{"MB", 0, "invalid size"},
+		{"ten", 0, "invalid size"},
+	}
+	for _, test := range tests {
+		got, err := parseSize(test.value)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("parseSize(%q) = %d, %v, want an error containing %q", test.value, got, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestSizeFlags(t *testing.T) {
+	var size sizeFlag
+	if err := size.Set("16EB"); err == nil {
+		t.Errorf("Set(16EB) accepted %d", size)
+	}
+	if err := size.Set("20000000TB"); err == nil || size != 0 {
+		t.Errorf("Set(20000000TB) = %v and left %d, want an error and no change", err, size)
+	}
+	if err := size.Set("2GB"); err != nil || size.String() != "2GB" {
+		t.Errorf("Set(2GB) = %v, shows %q", err, size.String())
+	}
+
+	var rate rateFlag
+	if err := rate.Set("10MB/s"); err != nil || rate != 10<<20 || rate.String() != "10MB/s" {
+		t.Errorf("Set(10MB/s) = %v, rate %d shown as %q", err, rate, rate.String())
+	}
+	if err := rate.Set("99999999TB/s"); err == nil || rate != 10<<20 {
+		t.Errorf("Set(99999999TB/s) = %v and left %d, want an error and no change", err, rate)
+	}
+	if largest := sizeFlag(math.MaxInt64); largest.String() != "9223372036854775807" {
+		t.Errorf("largest size shows as %q", largest.String())
+	}
+}
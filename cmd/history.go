@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"local-share/pkg/history"
+	"local-share/pkg/output"
+	"local-share/pkg/progress"
+)
+
+const (
+	DEFAULT_HISTORY_LIMIT = 20
+	HISTORY_TIME_FORMAT   = "2006-01-02 15:04"
+	HISTORY_SNIPPET       = 40 // Characters of a message shown in the history table
+)
+
+// openHistory returns the history store the receiver writes to
+func openHistory() (*history.Store, error) {
+	path, err := history.Path()
+	if err != nil {
+		return nil, err
+	}
+	return history.Open(path), nil
+}
+
+// historyFilterFlags registers the flags shared by inbox and history
+func historyFilterFlags(flags *flag.FlagSet) func(args []string) (history.Filter, error) {
+	limit := flags.Int("limit", DEFAULT_HISTORY_LIMIT, "show at most this `number` of the newest entries, 0 for all")
+	since := flags.String("since", "", "only entries newer than a `duration` (e.g. 24h) or a date (YYYY-MM-DD)")
+
+	return func(args []string) (history.Filter, error) {
+		filter := history.Filter{Query: strings.Join(args, " "), Limit: *limit}
+		if *since != "" {
+			start, err := parseSince(*since)
+			if err != nil {
+				return filter, err
+			}
+			filter.Since = start
+		}
+		return filter, nil
+	}
+}
+
+// parseSince parses --since as a duration back from now or a local date
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+	return time.Time{}, usageErrorf("invalid --since %q (use a duration like 24h or a date like 2025-03-14)", value)
+}
+
+func setupInbox(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	filter := historyFilterFlags(flags)
+	return func(args []string) error {
+		f, err := filter(args)
+		if err != nil {
+			return err
+		}
+		f.Type = history.TYPE_TEXT
+		records, err := listHistory(global, f)
+		if err != nil || len(records) == 0 {
+			return err
+		}
+
+		out := global.printer()
+		for _, r := range records {
+			out.Event("record", recordFields(r), "[%s] %s  %s (%s)\n  %s\n",
+				r.ID, r.Time.Local().Format(HISTORY_TIME_FORMAT), r.Device, r.Remote,
+				strings.ReplaceAll(r.Text, "\n", "\n  "))
+		}
+		return nil
+	}
+}
+
+func setupHistory(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	filter := historyFilterFlags(flags)
+	recordType := flags.String("type", "", "only entries of this `type`: text or file")
+	return func(args []string) error {
+		f, err := filter(args)
+		if err != nil {
+			return err
+		}
+		if *recordType != "" && *recordType != history.TYPE_TEXT && *recordType != history.TYPE_FILE {
+			return usageErrorf("invalid --type %q (use text or file)", *recordType)
+		}
+		f.Type = *recordType
+		records, err := listHistory(global, f)
+		if err != nil || len(records) == 0 {
+			return err
+		}
+
+		out := global.printer()
+		if out.JSON() {
+			for _, r := range records {
+				out.Event("record", recordFields(r), "")
+			}
+			return nil
+		}
+		var table strings.Builder
+		w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tTYPE\tFROM\tSIZE\tNAME OR TEXT")
+		for _, r := range records {
+			what := r.Name
+			if r.Type == history.TYPE_TEXT {
+				what = snippet(r.Text)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Time.Local().Format(HISTORY_TIME_FORMAT),
+				r.Type, r.Device, progress.FormatSize(r.Bytes), what)
+		}
+		w.Flush()
+		fmt.Print(table.String())
+		return nil
+	}
+}
+
+func setupHistoryShow(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	return func(args []string) error {
+		store, err := openHistory()
+		if err != nil {
+			return err
+		}
+		r, err := store.Get(args[0])
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "ID:       %s\n", r.ID)
+		fmt.Fprintf(&b, "Time:     %s\n", r.Time.Local().Format(time.RFC1123))
+		fmt.Fprintf(&b, "Type:     %s\n", r.Type)
+		fmt.Fprintf(&b, "From:     %s (%s)\n", r.Device, r.Remote)
+		fmt.Fprintf(&b, "Size:     %s\n", progress.FormatSize(r.Bytes))
+		if r.Type == history.TYPE_FILE {
+			fmt.Fprintf(&b, "Name:     %s\n", r.Name)
+			fmt.Fprintf(&b, "Path:     %s\n", r.Path)
+			fmt.Fprintf(&b, "SHA-256:  %s\n", r.SHA256)
+			fmt.Fprintf(&b, "Duration: %s\n", time.Duration(r.DurationMS)*time.Millisecond)
+		} else {
+			fmt.Fprintf(&b, "\n%s\n", r.Text)
+		}
+		global.printer().Event("record", recordFields(r), "%s", b.String())
+		return nil
+	}
+}
+
+// listHistory returns the selected records, printing a note when there are none
+func listHistory(global *globalOptions, filter history.Filter) ([]history.Record, error) {
+	store, err := openHistory()
+	if err != nil {
+		return nil, err
+	}
+	records, err := store.List(filter)
+	if err != nil {
+		global.printer().Error("Error reading history", err)
+		return nil, err
+	}
+	if len(records) == 0 && !global.printer().JSON() {
+		if filter.Query == "" && filter.Since.IsZero() {
+			fmt.Println("Nothing received yet.")
+		} else {
+			fmt.Println("No matching entries.")
+		}
+	}
+	return records, nil
+}
+
+// recordFields returns the JSON fields of a history record. The record time is "received"
+// because every event already has a "time".
+func recordFields(r history.Record) output.Fields {
+	fields := output.Fields{
+		"id":       r.ID,
+		"received": r.Time.UTC().Format(time.RFC3339Nano),
+		"type":     r.Type,
+		"from":     r.Device,
+		"remote":   r.Remote,
+		"bytes":    r.Bytes,
+	}
+	if r.Type == history.TYPE_TEXT {
+		fields["text"] = r.Text
+	} else {
+		fields["name"] = r.Name
+		fields["path"] = r.Path
+		fields["sha256"] = r.SHA256
+		fields["duration_ms"] = r.DurationMS
+	}
+	return fields
+}
+
+// snippet shortens a message to one line for the history table
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > HISTORY_SNIPPET {
+		return string(runes[:HISTORY_SNIPPET-1]) + "…"
+	}
+	return text
+}
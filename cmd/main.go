@@ -1,79 +1,660 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"local-share/pkg/config"
+	"local-share/pkg/crypto"
+	"local-share/pkg/peers"
 	"local-share/pkg/receiver"
 	"local-share/pkg/sender"
+	"local-share/pkg/watch"
 )
 
+const (
+	DEFAULT_PORT = 8080
+)
+
+// command is one entry of the command table
+type command struct {
+	name    string   // Words that select the command, e.g. "send file"
+	args    []string // Positional arguments: "[name]" is optional, a trailing "..." repeats
+	summary string
+	choices []string // Values completed for the first argument, if it has a fixed set
+
+	// setup registers the command's flags and returns the function that runs it
+	setup func(flags *flag.FlagSet, global *globalOptions) func(args []string) error
+}
+
+// commands is filled in by init because help and completion refer to it
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			name:    "receiver",
+			summary: "Start the receiver server",
+			setup:   setupReceiver,
+		},
+		{
+			name:    "send text",
+			args:    []string{"<ip|peer>", "<message>"},
+			summary: "Send a text message to a receiver",
+			setup:   setupSendText,
+		},
+		{
+			name:    "send file",
+			args:    []string{"[ip|peer]", "<filepath>"},
+			summary: "Send a file to a receiver, or to several with --to or --to-group",
+			setup:   setupSendFile,
+		},
+		{
+			name:    "serve",
+			args:    []string{"<dir>"},
+			summary: "Share a directory read-only for ls and get",
+			setup:   setupServe,
+		},
+		{
+			name:    "ls",
+			args:    []string{"<ip|peer>", "[dir]"},
+			summary: "List the files a receiver shares",
+			setup:   setupList,
+		},
+		{
+			name:    "get",
+			args:    []string{"<ip|peer>", "<name>"},
+			summary: "Download a file a receiver shares",
+			setup:   setupGet,
+		},
+		{
+			name:    "sync",
+			args:    []string{"<dir>", "<ip|peer>"},
+			summary: "Send only the new and changed files of a folder",
+			setup:   setupSync,
+		},
+		{
+			name:    "watch",
+			args:    []string{"<dir>", "<ip|peer>"},
+			summary: "Send new and changed files of a folder as they appear",
+			setup:   setupWatch,
+		},
+		{
+			name:    "peers add",
+			args:    []string{"<name>", "<address>"},
+			summary: "Save a receiver in the address book",
+			setup:   setupPeersAdd,
+		},
+		{
+			name:    "peers list",
+			summary: "List the receivers in the address book",
+			setup:   setupPeersList,
+		},
+		{
+			name:    "peers remove",
+			args:    []string{"<name>"},
+			summary: "Remove a receiver from the address book",
+			setup:   setupPeersRemove,
+		},
+		{
+			name:    "inbox",
+			args:    []string{"[search...]"},
+			summary: "Show the text messages the receiver received",
+			setup:   setupInbox,
+		},
+		{
+			name:    "history",
+			args:    []string{"[search...]"},
+			summary: "List received messages and files",
+			setup:   setupHistory,
+		},
+		{
+			name:    "history show",
+			args:    []string{"<id>"},
+			summary: "Show one received message or file",
+			setup:   setupHistoryShow,
+		},
+		{
+			name:    "completion",
+			args:    []string{"<shell>"},
+			summary: "Print a bash, zsh or fish completion script",
+			choices: []string{"bash", "zsh", "fish"},
+			setup:   setupCompletion,
+		},
+		{
+			name:    "help",
+			args:    []string{"[command...]"},
+			summary: "Show help for the tool or a command",
+			setup:   setupHelp,
+		},
+	}
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+	// Global flags may come before the command as well as after it
+	var global globalOptions
+	globals := flag.NewFlagSet(programName(), flag.ContinueOnError)
+	globals.SetOutput(os.Stderr)
+	globals.Usage = func() {}
+	global.register(globals)
+	if err := globals.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			printUsage(os.Stdout)
+			os.Exit(EXIT_OK)
+		}
+		printUsage(os.Stderr)
+		os.Exit(EXIT_USAGE)
+	}
+	args := globals.Args()
+
+	if len(args) < 1 {
+		printUsage(os.Stderr)
+		os.Exit(EXIT_USAGE)
+	}
+
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		if group := commandGroup(args[0]); group != "" && len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "Unknown %s subcommand: %s\n\n", group, args[1])
+		} else if group != "" {
+			fmt.Fprintf(os.Stderr, "Missing %s subcommand\n\n", group)
+		} else {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", strings.Join(args, " "))
+		}
+		printUsage(os.Stderr)
+		os.Exit(EXIT_USAGE)
+	}
+
+	os.Exit(exitCode(runCommand(cmd, &global, rest)))
+}
+
+// runCommand parses the command's flags and arguments and runs it
+func runCommand(cmd *command, global *globalOptions, args []string) error {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {}
+	global.register(flags)
+	run := cmd.setup(flags, global)
+
+	positional, err := parseInterspersed(flags, args)
+	if err == flag.ErrHelp {
+		printCommandUsage(os.Stdout, cmd, flags)
+		return nil
+	}
+	if err != nil {
+		printCommandUsage(os.Stderr, cmd, flags)
+		return &usageError{msg: err.Error()}
+	}
+
+	if min, max := cmd.argCount(); len(positional) < min || (max >= 0 && len(positional) > max) {
+		fmt.Fprintf(os.Stderr, "Wrong number of arguments for %s\n\n", cmd.name)
+		printCommandUsage(os.Stderr, cmd, flags)
+		return usageErrorf("wrong number of arguments")
+	}
+
+	err = run(positional)
+	var usage *usageError
+	if errors.As(err, &usage) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return err
+}
+
+// argCount returns how many positional arguments the command takes, max is -1 for no limit
+func (c *command) argCount() (min, max int) {
+	for _, arg := range c.args {
+		if strings.HasSuffix(arg, "...]") || strings.HasSuffix(arg, "...>") {
+			max = -1
+		}
+		if !strings.HasPrefix(arg, "[") {
+			min++
+		}
+	}
+	if max == 0 {
+		max = len(c.args)
+	}
+	return min, max
+}
+
+// parseInterspersed parses flags placed before, between or after the positional arguments.
+// Everything after "--" is positional.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		remaining := flags.Args()
+		if consumed := len(args) - len(remaining); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, remaining...), nil
+		}
+		if len(remaining) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, remaining[0])
+		args = remaining[1:]
+	}
+}
+
+// findCommand returns the command selected by the leading words of args and the remaining arguments
+func findCommand(args []string) (*command, []string) {
+	var found *command
+	words := 0
+	for i := range commands {
+		name := strings.Fields(commands[i].name)
+		if len(name) > len(args) || len(name) <= words {
+			continue
+		}
+		if strings.Join(args[:len(name)], " ") == commands[i].name {
+			found, words = &commands[i], len(name)
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return found, args[words:]
+}
+
+// commandGroup returns word if it starts multi-word commands such as "send text"
+func commandGroup(word string) string {
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.name, word+" ") {
+			return word
+		}
+	}
+	return ""
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [options] COMMAND [ARGS...]\n\n", programName())
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-30s %s\n", strings.Join(append([]string{cmd.name}, cmd.args...), " "), cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global options (before or after the command):")
+	globals := flag.NewFlagSet("", flag.ContinueOnError)
+	new(globalOptions).register(globals)
+	printFlags(w, globals)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Run '%s COMMAND --help' for the options of a command.\n", programName())
+}
+
+func printCommandUsage(w io.Writer, cmd *command, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: %s %s [options]", programName(), cmd.name)
+	for _, arg := range cmd.args {
+		fmt.Fprintf(w, " %s", arg)
+	}
+	fmt.Fprintf(w, "\n\n%s\n\nOptions:\n", cmd.summary)
+	printFlags(w, flags)
+}
+
+// printFlags lists flags in the "--name <value>" form used throughout the help
+func printFlags(w io.Writer, flags *flag.FlagSet) {
+	flags.VisitAll(func(f *flag.Flag) {
+		valueName, usage := flag.UnquoteUsage(f)
+		name := "--" + f.Name
+		if valueName != "" {
+			name += " <" + valueName + ">"
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s" {
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Fprintf(w, "  %-30s %s\n", name, usage)
+	})
+}
+
+// serverFlags registers the flags shared by receiver and serve. The returned function
+// builds the part of their config these flags and the config file determine.
+func serverFlags(flags *flag.FlagSet, global *globalOptions) func() (receiver.Config, config.Settings, error) {
+	port := flags.Int("port", DEFAULT_PORT, "TCP `port` to listen on (overrides listen in the config)")
+	keySource := flags.String("key-source", "", "where the password comes from: prompt, env:NAME or file:PATH (default $LOCALSHARE_KEY, then prompt)")
+	insecure := flags.Bool("insecure-no-password", false, "run without a password (transfers are NOT confidential)")
+	var allow, deny listFlag
+	flags.Var(&allow, "allow", "IP or CIDR `range` allowed to connect (repeatable, comma-separated)")
+	flags.Var(&deny, "deny", "IP or CIDR `range` refused (repeatable, comma-separated)")
+	rulesFile := flags.String("rules", "", "`file` with \"allow <rule>\" / \"deny <rule>\" lines")
+	maxConnections := flags.Int("max-connections", receiver.DEFAULT_MAX_CONNECTIONS, "maximum `number` of concurrent connections")
+	idleTimeout := flags.Duration("idle-timeout", receiver.DEFAULT_IDLE_TIMEOUT, "disconnect senders idle for this long")
+	shutdownTimeout := flags.Duration("shutdown-timeout", receiver.DEFAULT_SHUTDOWN_TIMEOUT, "how long running transfers may take to finish on Ctrl+C")
+	var limit, connLimit rateFlag
+	flags.Var(&limit, "limit", "bandwidth all connections may use together, `rate` like 10MB/s (default no limit)")
+	flags.Var(&connLimit, "limit-per-connection", "bandwidth each connection may use, `rate` like 2MB/s (default no limit)")
+
+	return func() (receiver.Config, config.Settings, error) {
+		logger, err := global.logger()
+		if err != nil {
+			return receiver.Config{}, config.Settings{}, err
+		}
+		settings, err := global.settings()
+		if err != nil {
+			return receiver.Config{}, config.Settings{}, err
+		}
+
+		// Flags win over the environment, which wins over the config file
+		listen := settings.Listen
+		if listen == "" || isSet(flags, "port") {
+			listen = fmt.Sprintf(":%d", *port)
+		}
+		source := firstSet(*keySource, settings.KeySource)
+		if err := crypto.ValidateKeySource(source); err != nil {
+			return receiver.Config{}, config.Settings{}, usageErrorf("%v", err)
+		}
+
+		return receiver.Config{
+			Listen:             listen,
+			KeySource:          source,
+			InsecureNoPassword: *insecure,
+			Allow:              allow,
+			Deny:               deny,
+			RulesFile:          *rulesFile,
+			MaxConnections:     *maxConnections,
+			IdleTimeout:        *idleTimeout,
+			RateLimit:          int64(limit),
+			ConnRateLimit:      int64(connLimit),
+			ShutdownTimeout:    *shutdownTimeout,
+			Logger:             logger,
+			Output:             global.printer(),
+		}, settings, nil
 	}
+}
+
+func setupReceiver(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	serverConfig := serverFlags(flags, global)
+	out := flags.String("out", "", "`directory` received files are stored in (default ./uploads)")
+	layout := flags.String("layout", "", "`template` of received file paths below --out, e.g. {date}/{sender}/{name} (default {name})")
+	conflict := flags.String("conflict", "", "what to do when a received file exists: overwrite, rename or reject (default overwrite)")
+	noHistory := flags.Bool("no-history", false, "do not record received messages and files for inbox and history")
+	approve := flags.Bool("approve", false, "ask before accepting each incoming file")
+	autoAccept := flags.String("auto-accept", "", "comma-separated device names or IPs (glob `patterns`) accepted without asking")
+	maxFileSize := sizeFlag(receiver.DEFAULT_MAX_FILE_SIZE)
+	flags.Var(&maxFileSize, "max-file-size", "largest file `size` accepted (e.g. 500MB, 4GB)")
+	maxTextSize := sizeFlag(receiver.DEFAULT_MAX_TEXT_SIZE)
+	flags.Var(&maxTextSize, "max-text-size", "largest text message `size` accepted (e.g. 64KB)")
+	var quota sizeFlag
+	flags.Var(&quota, "quota", "maximum total `size` of the uploads directory (e.g. 20GB)")
+	maxStreams := flags.Int("max-streams", receiver.DEFAULT_MAX_STREAMS, "most `connections` one parallel transfer may use (1 disables parallel transfers)")
 
-	command := os.Args[1]
+	return func(args []string) error {
+		config, settings, err := serverConfig()
+		if err != nil {
+			return err
+		}
+		if !*noHistory {
+			if config.History, err = openHistory(); err != nil {
+				return err
+			}
+		}
+
+		config.UploadDir = firstSet(*out, settings.UploadDir)
+		config.Layout = firstSet(*layout, settings.Layout)
+		config.Conflict = firstSet(*conflict, settings.Conflict)
+		config.Approve = *approve
+		config.AutoAccept = splitList(*autoAccept)
+		config.MaxFileSize = int64(maxFileSize)
+		config.MaxTextSize = int64(maxTextSize)
+		config.Quota = int64(quota)
+		config.MaxStreams = *maxStreams
 
-	switch command {
-	case "receiver":
 		// Run the server functionality
-		receiver.Start()
-	case "send":
-		if len(os.Args) < 3 {
-			printUsage()
-			os.Exit(1)
+		return receiver.Start(config)
+	}
+}
+
+func setupServe(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	serverConfig := serverFlags(flags, global)
+	return func(args []string) error {
+		config, _, err := serverConfig()
+		if err != nil {
+			return err
 		}
+		config.Share = args[0]
+		return receiver.Start(config)
+	}
+}
 
-		subCommand := os.Args[2]
+// sendFlags registers the flags shared by the send commands. The returned function builds
+// their config and resolves a peer name from the address book or the config file to its address.
+func sendFlags(flags *flag.FlagSet, global *globalOptions) func(target string) (sender.Config, string, error) {
+	port := flags.Int("port", DEFAULT_PORT, "receiver `port`, used when the address has none")
+	keySource := flags.String("key-source", "", "where the password comes from: prompt, env:NAME or file:PATH (default $LOCALSHARE_KEY, then prompt)")
+	insecure := flags.Bool("insecure-no-password", false, "send without a password (transfer is NOT confidential)")
+	var limit rateFlag
+	flags.Var(&limit, "limit", "bandwidth to use at most, `rate` like 10MB/s (default no limit)")
+	dialTimeout := flags.Duration("dial-timeout", sender.DEFAULT_DIAL_TIMEOUT, "how long connecting to the receiver may take")
+	retries := flags.Int("retries", 0, "connect again up to this `number` of times when the receiver cannot be reached")
+	wait := flags.Duration("wait", 0, "keep trying to connect for this long until the receiver is up, e.g. 2m")
 
-		switch subCommand {
-		case "text":
-			// Check arguments
-			if len(os.Args) < 5 {
-				fmt.Println("Usage: local-share send text <server-ip> <message>")
-				os.Exit(1)
+	return func(target string) (sender.Config, string, error) {
+		if *dialTimeout <= 0 {
+			return sender.Config{}, "", usageErrorf("--dial-timeout must be positive")
+		}
+		if *retries < 0 || *wait < 0 {
+			return sender.Config{}, "", usageErrorf("--retries and --wait must not be negative")
+		}
+		logger, err := global.logger()
+		if err != nil {
+			return sender.Config{}, "", err
+		}
+		settings, err := global.settings()
+		if err != nil {
+			return sender.Config{}, "", err
+		}
+		book, err := loadPeers()
+		if err != nil {
+			return sender.Config{}, "", err
+		}
+
+		// Names in the address book win over the [peers] of the config file and its profile,
+		// so a saved fingerprint and key source are never bypassed by a config entry
+		var peer peers.Peer
+		if saved, ok := book.Get(target); ok {
+			peer = saved
+		} else if addr, ok := settings.Peers[target]; ok {
+			peer.Address = addr
+		} else {
+			peer.Address = target
+		}
+		source := firstSet(*keySource, peer.KeySource, settings.KeySource)
+		if err := crypto.ValidateKeySource(source); err != nil {
+			return sender.Config{}, "", usageErrorf("%v", err)
+		}
+
+		return sender.Config{
+			Port:               *port,
+			KeySource:          source,
+			InsecureNoPassword: *insecure,
+			Fingerprint:        peer.Fingerprint,
+			RateLimit:          int64(limit),
+			DialTimeout:        *dialTimeout,
+			Retries:            *retries,
+			Wait:               *wait,
+			Logger:             logger,
+			Output:             global.printer(),
+		}, peer.Address, nil
+	}
+}
+
+func setupSendText(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	sendConfig := sendFlags(flags, global)
+	return func(args []string) error {
+		config, addr, err := sendConfig(args[0])
+		if err != nil {
+			return err
+		}
+		// Run the client text sending functionality
+		return sender.SendText(addr, args[1], config)
+	}
+}
+
+func setupSendFile(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	sendConfig := sendFlags(flags, global)
+	var to, toGroup listFlag
+	flags.Var(&to, "to", "send to each of these receivers at once, IPs or peer `names` (repeatable, comma-separated)")
+	flags.Var(&toGroup, "to-group", "send to every peer of this address book `group` at once (repeatable, comma-separated)")
+	streams := flags.Int("streams", 1, "split large files over this `number` of parallel connections (the receiver may allow fewer)")
+	return func(args []string) error {
+		if *streams < 1 || *streams > sender.MAX_STREAMS {
+			return usageErrorf("--streams must be between 1 and %d", sender.MAX_STREAMS)
+		}
+		if len(to) == 0 && len(toGroup) == 0 {
+			if len(args) != 2 {
+				return usageErrorf("missing receiver (give an IP or peer name, --to or --to-group)")
+			}
+			config, addr, err := sendConfig(args[0])
+			if err != nil {
+				return err
 			}
+			config.Streams = *streams
+			// Run the client file sending functionality
+			return sender.SendFile(addr, args[1], config)
+		}
+		if len(args) != 1 {
+			return usageErrorf("with --to or --to-group give only the file to send")
+		}
 
-			serverIP := os.Args[3]
-			message := os.Args[4]
+		names := append([]string{}, to...)
+		if len(toGroup) > 0 {
+			book, err := loadPeers()
+			if err != nil {
+				return err
+			}
+			for _, group := range toGroup {
+				members := book.Group(group)
+				if len(members) == 0 {
+					return usageErrorf("no peers in group %q (add them with 'peers add --group %s')", group, group)
+				}
+				for _, peer := range members {
+					names = append(names, peer.Name)
+				}
+			}
+		}
 
-			// Run the client text sending functionality
-			sender.SendText(serverIP, message)
-		case "file":
-			// Check arguments
-			if len(os.Args) < 5 {
-				fmt.Println("Usage: local-share send file <server-ip> <filepath>")
-				os.Exit(1)
+		var targets []sender.Target
+		seen := make(map[string]bool)
+		for _, name := range names {
+			if seen[name] {
+				continue
 			}
+			seen[name] = true
+			config, addr, err := sendConfig(name)
+			if err != nil {
+				return err
+			}
+			config.Streams = *streams
+			targets = append(targets, sender.Target{Name: name, Addr: addr, Config: config})
+		}
+		return sender.SendFileToAll(targets, args[0])
+	}
+}
 
-			serverIP := os.Args[3]
-			filePath := os.Args[4]
+func setupList(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	sendConfig := sendFlags(flags, global)
+	return func(args []string) error {
+		config, addr, err := sendConfig(args[0])
+		if err != nil {
+			return err
+		}
+		dir := ""
+		if len(args) > 1 {
+			dir = args[1]
+		}
+		return sender.List(addr, dir, config)
+	}
+}
 
-			// Run the client file sending functionality
-			sender.SendFile(serverIP, filePath)
-		default:
-			fmt.Printf("Unknown send subcommand: %s\n", subCommand)
-			printUsage()
-			os.Exit(1)
-		}
-	case "--help", "-h", "help":
-		printUsage()
-		os.Exit(0)
-	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		printUsage()
-		os.Exit(1)
-	}
-}
-
-func printUsage() {
-	fmt.Printf("Usage: %s COMMAND [ARGS...]\n\n", filepath.Base(os.Args[0]))
-	fmt.Println("Commands:")
-	fmt.Println("  receiver                  Start the receiver server")
-	fmt.Println("  send text <ip> <message>  Send a text message to a server")
-	fmt.Println("  send file <ip> <filepath> Send a file to a server")
-	fmt.Println("  help                      Show this help message")
+func setupGet(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	sendConfig := sendFlags(flags, global)
+	out := flags.String("out", "", "file or directory `path` to save to (default the file's name in the current directory)")
+	force := flags.Bool("force", false, "replace an existing file")
+	return func(args []string) error {
+		config, addr, err := sendConfig(args[0])
+		if err != nil {
+			return err
+		}
+		return sender.Get(addr, args[1], *out, *force, config)
+	}
+}
+
+func setupSync(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	sendConfig := sendFlags(flags, global)
+	var options sender.SyncOptions
+	flags.StringVar(&options.Target, "as", "", "`folder` on the receiver, below its upload directory (default the name of dir)")
+	flags.BoolVar(&options.Delete, "delete", false, "remove files from the receiver that dir no longer has")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "only show what would be sent and removed")
+	return func(args []string) error {
+		config, addr, err := sendConfig(args[1])
+		if err != nil {
+			return err
+		}
+		return sender.Sync(addr, args[0], options, config)
+	}
+}
+
+func setupWatch(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	sendConfig := sendFlags(flags, global)
+	var ignore listFlag
+	flags.Var(&ignore, "ignore", "filename glob `pattern` not to send, e.g. '*.log' (repeatable, comma-separated; hidden and temporary files are always ignored)")
+	debounce := flags.Duration("debounce", watch.DEFAULT_DEBOUNCE, "wait until a file did not change for this `duration` before sending it")
+	poll := flags.Duration("poll", 0, "check the folder every `interval` instead of using file notifications (for network drives)")
+	return func(args []string) error {
+		for _, pattern := range ignore {
+			if err := watch.ValidatePattern(pattern); err != nil {
+				return usageErrorf("invalid --ignore pattern %q: %v", pattern, err)
+			}
+		}
+		if *debounce < 0 || *poll < 0 {
+			return usageErrorf("--debounce and --poll must not be negative")
+		}
+		config, addr, err := sendConfig(args[1])
+		if err != nil {
+			return err
+		}
+		return sender.Watch(addr, args[0], sender.WatchOptions{Ignore: ignore, Debounce: *debounce, Poll: *poll}, config)
+	}
+}
+
+func setupCompletion(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	return func(args []string) error {
+		script, err := completionScript(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Print(script)
+		return nil
+	}
+}
+
+func setupHelp(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	return func(args []string) error {
+		if len(args) == 0 {
+			printUsage(os.Stdout)
+			return nil
+		}
+
+		cmd, rest := findCommand(args)
+		if cmd == nil || len(rest) > 0 {
+			return usageErrorf("unknown command %q", strings.Join(args, " "))
+		}
+		printCommandUsage(os.Stdout, cmd, commandFlags(cmd))
+		return nil
+	}
+}
+
+// commandFlags returns the flags a command accepts, including the global ones
+func commandFlags(cmd *command) *flag.FlagSet {
+	global := new(globalOptions)
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	global.register(flags)
+	cmd.setup(flags, global)
+	return flags
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/output"
+	"local-share/pkg/peers"
+)
+
+// loadPeers opens the address book
+func loadPeers() (*peers.Book, error) {
+	path, err := peers.Path()
+	if err != nil {
+		return nil, err
+	}
+	book, err := peers.Load(path)
+	if err != nil {
+		return nil, usageErrorf("invalid address book: %v", err)
+	}
+	return book, nil
+}
+
+func setupPeersAdd(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	fingerprint := flags.String("fingerprint", "", "key `fingerprint` the receiver prints, checked before sending")
+	keySource := flags.String("key-source", "", "where the password for this peer comes from: prompt, env:NAME or file:PATH")
+	var groups listFlag
+	flags.Var(&groups, "group", "`name` of a group the peer belongs to, for send file --to-group (repeatable, comma-separated)")
+
+	return func(args []string) error {
+		peer := peers.Peer{Name: args[0], Address: args[1], Fingerprint: *fingerprint, KeySource: *keySource, Groups: groups}
+		if err := peers.ValidateName(peer.Name); err != nil {
+			return usageErrorf("%v", err)
+		}
+		for _, group := range peer.Groups {
+			if err := peers.ValidateName(group); err != nil {
+				return usageErrorf("invalid group: %v", err)
+			}
+		}
+		if peer.Fingerprint != "" {
+			if err := crypto.ValidateFingerprint(peer.Fingerprint); err != nil {
+				return usageErrorf("%v", err)
+			}
+		}
+		if err := crypto.ValidateKeySource(peer.KeySource); err != nil {
+			return usageErrorf("%v", err)
+		}
+
+		book, err := loadPeers()
+		if err != nil {
+			return err
+		}
+		replaced, err := book.Add(peer)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		if err := book.Save(); err != nil {
+			global.printer().Error("Error saving address book", err)
+			return err
+		}
+
+		verb := "Added"
+		if replaced {
+			verb = "Updated"
+		}
+		global.printer().Event("peer_saved", peerFields(peer, output.Fields{"replaced": replaced}),
+			"%s peer %s (%s)\n", verb, peer.Name, peer.Address)
+		return nil
+	}
+}
+
+func setupPeersList(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	return func(args []string) error {
+		book, err := loadPeers()
+		if err != nil {
+			return err
+		}
+
+		out := global.printer()
+		list := book.List()
+		if out.JSON() {
+			for _, peer := range list {
+				out.Event("peer", peerFields(peer, nil), "")
+			}
+			return nil
+		}
+		if len(list) == 0 {
+			fmt.Printf("No peers saved. Add one with '%s peers add <name> <address>'.\n", programName())
+			return nil
+		}
+
+		var table strings.Builder
+		w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tADDRESS\tFINGERPRINT\tKEY SOURCE\tGROUPS")
+		for _, peer := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", peer.Name, peer.Address, orDash(peer.Fingerprint), orDash(peer.KeySource),
+				orDash(strings.Join(peer.Groups, ",")))
+		}
+		w.Flush()
+		fmt.Print(table.String())
+		return nil
+	}
+}
+
+func setupPeersRemove(flags *flag.FlagSet, global *globalOptions) func(args []string) error {
+	return func(args []string) error {
+		book, err := loadPeers()
+		if err != nil {
+			return err
+		}
+		if err := book.Remove(args[0]); err != nil {
+			return usageErrorf("%v", err)
+		}
+		if err := book.Save(); err != nil {
+			global.printer().Error("Error saving address book", err)
+			return err
+		}
+		global.printer().Event("peer_removed", output.Fields{"name": args[0]}, "Removed peer %s\n", args[0])
+		return nil
+	}
+}
+
+// peerFields returns the JSON fields describing peer, added to extra
+func peerFields(peer peers.Peer, extra output.Fields) output.Fields {
+	fields := output.Fields{"name": peer.Name, "address": peer.Address}
+	if peer.Fingerprint != "" {
+		fields["fingerprint"] = peer.Fingerprint
+	}
+	if peer.KeySource != "" {
+		fields["key_source"] = peer.KeySource
+	}
+	if len(peer.Groups) > 0 {
+		fields["groups"] = peer.Groups
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	return fields
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
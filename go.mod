@@ -5,6 +5,6 @@ go 1.23.0
 toolchain go1.24.1
 
 require (
-	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/sys v0.31.0
 	golang.org/x/term v0.30.0
 )
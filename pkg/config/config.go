@@ -0,0 +1,280 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	APP_DIR     = "local-share"
+	CONFIG_FILE = "config.toml"
+)
+
+// Environment variables that override the config file
+const (
+	ENV_CONFIG     = "LOCALSHARE_CONFIG"     // Path of the config file
+	ENV_PROFILE    = "LOCALSHARE_PROFILE"    // Profile to use
+	ENV_LISTEN     = "LOCALSHARE_LISTEN"     // Receiver listen address
+	ENV_UPLOAD_DIR = "LOCALSHARE_UPLOAD_DIR" // Receiver upload directory
+	ENV_KEY_SOURCE = "LOCALSHARE_KEY_SOURCE" // Where the password comes from
+	ENV_CONFLICT   = "LOCALSHARE_CONFLICT"   // Receiver conflict policy
+	ENV_LAYOUT     = "LOCALSHARE_LAYOUT"     // Receiver layout of received files
+)
+
+// Settings are the defaults a config file, a profile or the environment provide.
+// Empty fields are not set and leave the built-in default in place.
+type Settings struct {
+	Listen    string            // Receiver listen address, e.g. ":9000"
+	UploadDir string            // Directory the receiver stores files in
+	KeySource string            // Where the password comes from: "prompt", "env:NAME" or "file:PATH"
+	Conflict  string            // What the receiver does with existing files: overwrite, rename or reject
+	Layout    string            // Where the receiver stores files below UploadDir, e.g. "{date}/{sender}/{name}"
+	Peers     map[string]string // Receiver addresses by name, for names not in the peers address book
+}
+
+// Config is a parsed config file: top-level settings and named profiles
+type Config struct {
+	Settings
+	Profiles map[string]Settings
+}
+
+// Dir returns the directory holding local-share's configuration:
+// $XDG_CONFIG_HOME/local-share, or ~/.config/local-share on every platform
+func Dir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, APP_DIR), nil
+}
+
+// DataDir returns the directory holding local-share's data such as the transfer history:
+// $XDG_DATA_HOME/local-share, or ~/.local/share/local-share
+func DataDir() (string, error) {
+	base := os.Getenv("XDG_DATA_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(base, APP_DIR), nil
+}
+
+// Path returns the config file to read: $LOCALSHARE_CONFIG if set, otherwise config.toml in Dir
+func Path() (string, error) {
+	if path := os.Getenv(ENV_CONFIG); path != "" {
+		return path, nil
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, CONFIG_FILE), nil
+}
+
+// Load reads the config file at path. A missing file is an empty config.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Parse reads a config file:
+//
+//	listen = ":9000"
+//	upload_dir = "~/Downloads/local-share"
+//	layout = "{date}/{sender}/{name}"
+//
+//	[peers]
+//	desk = "192.168.1.20:9000"
+//
+//	[profile.office]
+//	key_source = "file:~/.config/local-share/office.key"
+func Parse(r io.Reader) (*Config, error) {
+	root, err := parseTOML(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	for key, value := range root {
+		if key != "profile" {
+			continue
+		}
+		profiles, ok := value.(table)
+		if !ok {
+			return nil, fmt.Errorf("profile must be a table, like [profile.office]")
+		}
+		config.Profiles = make(map[string]Settings)
+		for name, value := range profiles {
+			profile, ok := value.(table)
+			if !ok {
+				return nil, fmt.Errorf("profile.%s must be a table", name)
+			}
+			if config.Profiles[name], err = decodeSettings(profile, "profile."+name); err != nil {
+				return nil, err
+			}
+		}
+		delete(root, key)
+	}
+
+	if config.Settings, err = decodeSettings(root, ""); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Profile returns the top-level settings overridden by the named profile.
+// An empty name returns the top-level settings.
+func (c *Config) Profile(name string) (Settings, error) {
+	if name == "" {
+		return c.Settings, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Settings{}, fmt.Errorf("unknown profile %q (have: %s)", name, c.profileNames())
+	}
+	return c.Settings.Merge(profile), nil
+}
+
+func (c *Config) profileNames() string {
+	if len(c.Profiles) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprint(names)
+}
+
+// FromEnv returns the settings given by LOCALSHARE_* environment variables
+func FromEnv() Settings {
+	return Settings{
+		Listen:    os.Getenv(ENV_LISTEN),
+		UploadDir: expandHome(os.Getenv(ENV_UPLOAD_DIR)),
+		KeySource: os.Getenv(ENV_KEY_SOURCE),
+		Conflict:  os.Getenv(ENV_CONFLICT),
+		Layout:    os.Getenv(ENV_LAYOUT),
+	}
+}
+
+// Merge returns s with every field set in over replacing its own. Peers are combined.
+func (s Settings) Merge(over Settings) Settings {
+	if over.Listen != "" {
+		s.Listen = over.Listen
+	}
+	if over.UploadDir != "" {
+		s.UploadDir = over.UploadDir
+	}
+	if over.KeySource != "" {
+		s.KeySource = over.KeySource
+	}
+	if over.Conflict != "" {
+		s.Conflict = over.Conflict
+	}
+	if over.Layout != "" {
+		s.Layout = over.Layout
+	}
+	if len(over.Peers) > 0 {
+		peers := make(map[string]string, len(s.Peers)+len(over.Peers))
+		for name, addr := range s.Peers {
+			peers[name] = addr
+		}
+		for name, addr := range over.Peers {
+			peers[name] = addr
+		}
+		s.Peers = peers
+	}
+	return s
+}
+
+// decodeSettings reads the settings keys of one table; prefix names the table in errors
+func decodeSettings(t table, prefix string) (Settings, error) {
+	var s Settings
+	name := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	for key, value := range t {
+		if key == "peers" {
+			peers, ok := value.(table)
+			if !ok {
+				return s, fmt.Errorf("%s must be a table, like [peers]", name(key))
+			}
+			s.Peers = make(map[string]string, len(peers))
+			for peer, addr := range peers {
+				text, ok := addr.(string)
+				if !ok {
+					return s, fmt.Errorf("%s.%s must be a quoted address", name(key), peer)
+				}
+				s.Peers[peer] = text
+			}
+			continue
+		}
+
+		switch key {
+		case "listen", "upload_dir", "key_source", "conflict", "layout":
+		default:
+			return s, fmt.Errorf("unknown setting %q", name(key))
+		}
+		text, ok := value.(string)
+		if !ok {
+			return s, fmt.Errorf("%s must be a quoted string", name(key))
+		}
+		switch key {
+		case "listen":
+			s.Listen = text
+		case "upload_dir":
+			s.UploadDir = expandHome(text)
+		case "key_source":
+			if strings.HasPrefix(text, "file:") {
+				text = "file:" + expandHome(text[len("file:"):])
+			}
+			s.KeySource = text
+		case "conflict":
+			s.Conflict = text
+		case "layout":
+			s.Layout = text
+		}
+	}
+	return s, nil
+}
+
+// expandHome replaces a leading "~/" with the user's home directory
+func expandHome(path string) string {
+	if len(path) < 2 || path[:2] != "~/" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
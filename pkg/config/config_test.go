@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+listen = ":9000"
+upload_dir = "~/Downloads/local-share"
+key_source = "env:HOME_KEY"
+layout = "{sender}/{name}"
+
+[peers]
+desk = "192.168.1.20:9000"
+laptop = "192.168.1.30"
+
+[profile.office]
+key_source = "file:~/.config/local-share/office.key"
+conflict = "rename"
+
+[profile.office.peers]
+printer = "10.0.0.5"
+desk = "10.0.0.20"
+
+[profile.lab]
+listen = ":9100"
+`
+
+func TestParse(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	config, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Settings{
+		Listen:    ":9000",
+		UploadDir: filepath.Join(home, "Downloads/local-share"),
+		KeySource: "env:HOME_KEY",
+		Layout:    "{sender}/{name}",
+		Peers:     map[string]string{"desk": "192.168.1.20:9000", "laptop": "192.168.1.30"},
+	}
+	if !reflect.DeepEqual(config.Settings, want) {
+		t.Errorf("settings = %+v, want %+v", config.Settings, want)
+	}
+	office := Settings{
+		KeySource: "file:" + filepath.Join(home, ".config/local-share/office.key"),
+		Conflict:  "rename",
+		Peers:     map[string]string{"printer": "10.0.0.5", "desk": "10.0.0.20"},
+	}
+	if !reflect.DeepEqual(config.Profiles["office"], office) {
+		t.Errorf("office profile = %+v, want %+v", config.Profiles["office"], office)
+	}
+	if len(config.Profiles) != 2 {
+		t.Errorf("profiles = %v, want office and lab", config.Profiles)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{`listn = ":9000"`, `unknown setting "listn"`},
+		{"[profile.office]\nlayot = \"{name}\"", `unknown setting "profile.office.layot"`},
+		{"listen = 9000", "listen must be a quoted string"},
+		{"[profile.office]\nconflict = true", "profile.office.conflict must be a quoted string"},
+		{`peers = "desk"`, "peers must be a table"},
+		{"[peers]\ndesk = 9000", "peers.desk must be a quoted address"},
+		{`profile = "office"`, "profile must be a table"},
+		{"[profile]\noffice = \"x\"", "profile.office must be a table"},
+		{"[unknown]\nkey = \"x\"", `unknown setting "unknown"`},
+	}
+	for _, test := range tests {
+		_, err := Parse(strings.NewReader(test.input))
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("Parse(%q) error = %v, want it to contain %q", test.input, err, test.wantErr)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	config, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top, err := config.Profile("")
+	if err != nil || !reflect.DeepEqual(top, config.Settings) {
+		t.Errorf("Profile(\"\") = %+v, %v, want the top-level settings", top, err)
+	}
+
+	office, err := config.Profile("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The profile overrides what it sets, keeps the rest and adds to the peers
+	if office.Listen != ":9000" || office.Layout != "{sender}/{name}" {
+		t.Errorf("office profile lost top-level settings: %+v", office)
+	}
+	if office.Conflict != "rename" || !strings.HasSuffix(office.KeySource, "office.key") {
+		t.Errorf("office profile settings not applied: %+v", office)
+	}
+	wantPeers := map[string]string{"desk": "10.0.0.20", "laptop": "192.168.1.30", "printer": "10.0.0.5"}
+	if !reflect.DeepEqual(office.Peers, wantPeers) {
+		t.Errorf("office peers = %v, want %v", office.Peers, wantPeers)
+	}
+	// Merging must not change the top-level peers
+	if config.Settings.Peers["desk"] != "192.168.1.20:9000" || config.Settings.Peers["printer"] != "" {
+		t.Errorf("top-level peers changed: %v", config.Settings.Peers)
+	}
+
+	lab, err := config.Profile("lab")
+	if err != nil || lab.Listen != ":9100" || lab.KeySource != "env:HOME_KEY" {
+		t.Errorf("Profile(\"lab\") = %+v, %v", lab, err)
+	}
+
+	if _, err := config.Profile("home"); err == nil || !strings.Contains(err.Error(), "[lab office]") {
+		t.Errorf("Profile(\"home\") error = %v, want it to list the profiles", err)
+	}
+	if _, err := (&Config{}).Profile("home"); err == nil || !strings.Contains(err.Error(), "none") {
+		t.Errorf("Profile on an empty config error = %v, want it to say there are none", err)
+	}
+}
+
+func TestEnvOverridesProfile(t *testing.T) {
+	config, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	office, err := config.Profile("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{ENV_LISTEN, ENV_UPLOAD_DIR, ENV_KEY_SOURCE, ENV_CONFLICT, ENV_LAYOUT} {
+		t.Setenv(name, "")
+	}
+	t.Setenv(ENV_CONFLICT, "reject")
+	t.Setenv(ENV_LAYOUT, "{date}/{name}")
+
+	// Config file, then profile, then environment; unset variables change nothing
+	settings := office.Merge(FromEnv())
+	want := office
+	want.Conflict, want.Layout = "reject", "{date}/{name}"
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("settings = %+v, want %+v", settings, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	config, err := Load(filepath.Join(dir, "missing.toml"))
+	if err != nil || !reflect.DeepEqual(config, &Config{}) {
+		t.Errorf("Load of a missing file = %+v, %v, want an empty config", config, err)
+	}
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("listen = :9000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), path+": line 1:") {
+		t.Errorf("Load error = %v, want it to name the file and line", err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	t.Setenv(ENV_CONFIG, "")
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("USERPROFILE", "/home/me")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+	// The same place on every platform, as the documentation says
+	if path, err := Path(); err != nil || path != filepath.Join("/home/me", ".config", APP_DIR, CONFIG_FILE) {
+		t.Errorf("Path() without XDG_CONFIG_HOME = %q, %v", path, err)
+	}
+	if dir, err := DataDir(); err != nil || dir != filepath.Join("/home/me", ".local", "share", APP_DIR) {
+		t.Errorf("DataDir() without XDG_DATA_HOME = %q, %v", dir, err)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	if path, err := Path(); err != nil || path != filepath.Join("/xdg", APP_DIR, CONFIG_FILE) {
+		t.Errorf("Path() = %q, %v", path, err)
+	}
+	t.Setenv(ENV_CONFIG, "/etc/local-share.toml")
+	if path, err := Path(); err != nil || path != "/etc/local-share.toml" {
+		t.Errorf("Path() with %s = %q, %v", ENV_CONFIG, path, err)
+	}
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// table is a parsed TOML table: values are string, int64, bool or table
+type table map[string]any
+
+// parseTOML parses the subset of TOML used by config files: comments, [table] and
+// [table.sub] headers, and key = value lines with strings, integers and booleans
+func parseTOML(r io.Reader) (table, error) {
+	root := make(table)
+	current := root
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fail := func(format string, args ...any) (table, error) {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.LastIndex(line, "]")
+			if end < 0 || strings.HasPrefix(line, "[[") {
+				return fail("invalid table header %q", line)
+			}
+			if rest := strings.TrimSpace(line[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return fail("unexpected %q after table header", rest)
+			}
+			path, err := parseKeyPath(line[1:end])
+			if err != nil {
+				return fail("%v", err)
+			}
+			current = root
+			for _, key := range path {
+				switch next := current[key].(type) {
+				case nil:
+					child := make(table)
+					current[key] = child
+					current = child
+				case table:
+					current = next
+				default:
+					return fail("%q is already a value", key)
+				}
+			}
+			continue
+		}
+
+		keyText, valueText, found := strings.Cut(line, "=")
+		if !found {
+			return fail("expected key = value")
+		}
+		path, err := parseKeyPath(keyText)
+		if err != nil {
+			return fail("%v", err)
+		}
+		if len(path) != 1 {
+			return fail("dotted keys are not supported, use a [table] header")
+		}
+		value, err := parseValue(strings.TrimSpace(valueText))
+		if err != nil {
+			return fail("%v", err)
+		}
+		if _, exists := current[path[0]]; exists {
+			return fail("%q is set twice", path[0])
+		}
+		current[path[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// parseKeyPath splits a.b."c d" into its keys
+func parseKeyPath(text string) ([]string, error) {
+	var keys []string
+	text = strings.TrimSpace(text)
+	for {
+		var key string
+		if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+			value, rest, err := parseString(text)
+			if err != nil {
+				return nil, err
+			}
+			key, text = value, strings.TrimSpace(rest)
+		} else {
+			end := strings.IndexAny(text, ". \t")
+			if end < 0 {
+				end = len(text)
+			}
+			key, text = text[:end], strings.TrimSpace(text[end:])
+			if !isBareKey(key) {
+				return nil, fmt.Errorf("invalid key %q", key)
+			}
+		}
+		keys = append(keys, key)
+
+		if text == "" {
+			return keys, nil
+		}
+		if !strings.HasPrefix(text, ".") {
+			return nil, fmt.Errorf("unexpected %q in key", text)
+		}
+		text = strings.TrimSpace(text[1:])
+	}
+}
+
+func isBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue parses a string, integer or boolean followed by an optional comment
+func parseValue(text string) (any, error) {
+	var value any
+	var rest string
+	switch {
+	case strings.HasPrefix(text, `"`), strings.HasPrefix(text, "'"):
+		s, r, err := parseString(text)
+		if err != nil {
+			return nil, err
+		}
+		value, rest = s, r
+	default:
+		// Anything after a "#" is a comment
+		token, _, _ := strings.Cut(text, "#")
+		token = strings.TrimSpace(token)
+		switch token {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		default:
+			n, err := strconv.ParseInt(strings.ReplaceAll(token, "_", ""), 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported value %q (use a quoted string, integer or boolean)", token)
+			}
+			value = n
+		}
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return nil, fmt.Errorf("unexpected %q after value", rest)
+	}
+	return value, nil
+}
+
+// parseString parses a basic ("...") or literal ('...') string at the start of text
+// and returns it with the text after the closing quote
+func parseString(text string) (string, string, error) {
+	if strings.HasPrefix(text, "'") {
+		end := strings.Index(text[1:], "'")
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return text[1 : end+1], text[end+2:], nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch c {
+		case '"':
+			return b.String(), text[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(text) {
+				return "", "", fmt.Errorf("unterminated string")
+			}
+			switch text[i] {
+			case '"', '\\':
+				b.WriteByte(text[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'u', 'U':
+				size := 4
+				if text[i] == 'U' {
+					size = 8
+				}
+				if i+size >= len(text) {
+					return "", "", fmt.Errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(text[i+1:i+1+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", "", fmt.Errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				i += size
+			default:
+				return "", "", fmt.Errorf("invalid escape \\%c", text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    table
+		wantErr string // Part of the error, empty if parsing succeeds
+	}{
+		{"empty", "", table{}, ""},
+		{"comments and blank lines", "# comment\n\n   # indented comment\nkey = \"value\" # trailing\n", table{"key": "value"}, ""},
+		{"hash inside string", `key = "a # b"`, table{"key": "a # b"}, ""},
+		{"spaces around equals", "key=\"v\"\n  other   =   'w'  ", table{"key": "v", "other": "w"}, ""},
+
+		{"basic string escapes", `key = "tab\tnew\nquote\" back\\ cr\r"`, table{"key": "tab\tnew\nquote\" back\\ cr\r"}, ""},
+		{"unicode escapes", `key = "caf\u00e9 \U0001F600"`, table{"key": "café 😀"}, ""},
+		{"literal string keeps backslashes", `key = 'C:\Users\me'`, table{"key": `C:\Users\me`}, ""},
+		{"literal string with double quote", `key = 'say "hi"'`, table{"key": `say "hi"`}, ""},
+		{"equals sign in value", `key = "a=b"`, table{"key": "a=b"}, ""},
+		{"invalid escape", `key = "\q"`, nil, `invalid escape`},
+		{"short unicode escape", `key = "\u00"`, nil, "invalid unicode escape"},
+		{"invalid code point", `key = "\UFFFFFFFF"`, nil, "invalid unicode escape"},
+		{"unterminated basic string", `key = "open`, nil, "unterminated string"},
+		{"unterminated literal string", `key = 'open`, nil, "unterminated string"},
+		{"text after string", `key = "a" "b"`, nil, "after value"},
+
+		{"integers", "a = 42\nb = -7\nc = 1_000\nd = 0x1F", table{"a": int64(42), "b": int64(-7), "c": int64(1000), "d": int64(31)}, ""},
+		{"booleans", "a = true\nb = false # off", table{"a": true, "b": false}, ""},
+		{"bare word", "key = value", nil, "unsupported value"},
+		{"float", "key = 1.5", nil, "unsupported value"},
+		{"array", `key = ["a", "b"]`, nil, "unsupported value"},
+		{"inline table", `key = { a = 1 }`, nil, "unsupported value"},
+		{"missing value", "key =", nil, "unsupported value"},
+		{"missing equals", "key", nil, "expected key = value"},
+
+		{"tables", "top = 1\n[peers]\ndesk = \"d\"\n[profile.office]\nlisten = \":9000\"",
+			table{"top": int64(1), "peers": table{"desk": "d"}, "profile": table{"office": table{"listen": ":9000"}}}, ""},
+		{"quoted keys", "\"my key\" = 1\n['profile'.\"with space\"]\nx = 2",
+			table{"my key": int64(1), "profile": table{"with space": table{"x": int64(2)}}}, ""},
+		{"comment after header", "[peers] # known receivers\ndesk = \"d\"", table{"peers": table{"desk": "d"}}, ""},
+		{"text after header", "[peers] desk", nil, "after table header"},
+		{"unterminated header", "[peers", nil, "invalid table header"},
+		{"array of tables", "[[peers]]", nil, "invalid table header"},
+		{"table over value", "peers = 1\n[peers]", nil, `"peers" is already a value`},
+		{"dotted key", "a.b = 1", nil, "dotted keys are not supported"},
+		{"invalid bare key", "my key = 1", nil, "unexpected"},
+		{"empty key", "= 1", nil, "invalid key"},
+
+		{"duplicate key", "key = 1\nkey = 2", nil, `"key" is set twice`},
+		{"duplicate key in table", "[peers]\ndesk = \"a\"\n[other]\n[peers]\ndesk = \"b\"", nil, `"desk" is set twice`},
+		{"same key in different tables", "key = 1\n[t]\nkey = 2", table{"key": int64(1), "t": table{"key": int64(2)}}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML(strings.NewReader(test.input))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("parseTOML(%q) error = %v, want it to contain %q", test.input, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTOML(%q) failed: %v", test.input, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseTOML(%q) = %#v, want %#v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrorLine(t *testing.T) {
+	_, err := parseTOML(strings.NewReader("# settings\nlisten = \":9000\"\n\nlayout = oops\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 4:") {
+		t.Errorf("error = %v, want it to name line 4", err)
+	}
+}
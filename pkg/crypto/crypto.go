@@ -4,41 +4,145 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"strings"
 	"syscall"
+	"unicode"
 
 	"golang.org/x/term"
 )
 
-// GetEncryptionKey retrieves the encryption key from environment variable or prompts user
-func GetEncryptionKey(confirmPassword bool) (string, error) {
-	// First try environment variable
-	key := os.Getenv("LOCALSHARE_KEY")
-	if key != "" {
-		return PadKey(key), nil
+const (
+	MIN_PASSWORD_LENGTH  = 8
+	MIN_PASSWORD_ENTROPY = 45 // Estimated bits of entropy a password must reach
+	FINGERPRINT_BYTES    = 8  // Digest bytes shown in a key fingerprint
+
+	// CIPHER names the encryption used for all transfers in the protocol handshake
+	CIPHER = "aes-256-cfb"
+
+	// INSECURE_PASSWORD is the well-known password used by --insecure-no-password.
+	// Anyone on the network can read transfers made with it.
+	INSECURE_PASSWORD = "local-share-insecure-no-password"
+
+	// Key sources understood by GetEncryptionKeyFrom
+	KEY_SOURCE_PROMPT = "prompt"
+	KEY_SOURCE_ENV    = "env:"  // Followed by the name of an environment variable
+	KEY_SOURCE_FILE   = "file:" // Followed by the path of a file holding the password
+)
+
+// keyboardRows are the rows of a US keyboard, unshifted and shifted, for spotting keyboard walks
+var keyboardRows = []string{"`1234567890-=", "~!@#$%^&*()_+", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+// commonPasswords lists passwords that are rejected regardless of their estimated strength
+var commonPasswords = map[string]bool{
+	"password":    true,
+	"password1":   true,
+	"password123": true,
+	"12345678":    true,
+	"123456789":   true,
+	"1234567890":  true,
+	"11111111":    true,
+	"qwerty123":   true,
+	"qwertyuiop":  true,
+	"iloveyou":    true,
+	"letmein1":    true,
+	"welcome1":    true,
+	"localshare":  true,
+	"local-share": true,
+}
+
+// GetEncryptionKey retrieves the encryption key from environment variable or prompts user.
+// When insecureNoPassword is set no password is asked for and the well-known insecure key is used.
+func GetEncryptionKey(confirmPassword bool, insecureNoPassword bool) (string, error) {
+	return GetEncryptionKeyFrom("", confirmPassword, insecureNoPassword)
+}
+
+// GetEncryptionKeyFrom retrieves the encryption key from source: "prompt", "env:NAME" or "file:PATH".
+// An empty source uses LOCALSHARE_KEY if it is set and prompts otherwise.
+func GetEncryptionKeyFrom(source string, confirmPassword bool, insecureNoPassword bool) (string, error) {
+	if insecureNoPassword {
+		return PadKey(INSECURE_PASSWORD), nil
 	}
+	if err := ValidateKeySource(source); err != nil {
+		return "", err
+	}
+
+	switch {
+	case source == "":
+		// First try environment variable
+		if key := os.Getenv("LOCALSHARE_KEY"); key != "" {
+			return checkedKey("LOCALSHARE_KEY", key)
+		}
+	case strings.HasPrefix(source, KEY_SOURCE_ENV):
+		name := source[len(KEY_SOURCE_ENV):]
+		key := os.Getenv(name)
+		if key == "" {
+			return "", fmt.Errorf("key source %s: %s is not set", source, name)
+		}
+		return checkedKey(name, key)
+	case strings.HasPrefix(source, KEY_SOURCE_FILE):
+		path := source[len(KEY_SOURCE_FILE):]
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("key source %s: %v", source, err)
+		}
+		return checkedKey(path, strings.TrimRight(string(data), "\r\n"))
+	}
+	return promptKey(confirmPassword)
+}
+
+// ValidateKeySource checks that source is empty, "prompt", "env:NAME" or "file:PATH"
+func ValidateKeySource(source string) error {
+	switch {
+	case source == "", source == KEY_SOURCE_PROMPT:
+		return nil
+	case strings.HasPrefix(source, KEY_SOURCE_ENV) && len(source) > len(KEY_SOURCE_ENV):
+		return nil
+	case strings.HasPrefix(source, KEY_SOURCE_FILE) && len(source) > len(KEY_SOURCE_FILE):
+		return nil
+	}
+	return fmt.Errorf("invalid key source %q (use prompt, env:NAME or file:PATH)", source)
+}
+
+// checkedKey validates a password read from origin and pads it
+func checkedKey(origin, key string) (string, error) {
+	if err := ValidatePassword(key); err != nil {
+		return "", fmt.Errorf("%s rejected: %v", origin, err)
+	}
+	return PadKey(key), nil
+}
 
-	// If no environment variable, prompt user
+// promptKey asks for the password on the terminal
+func promptKey(confirmPassword bool) (string, error) {
+	// Prompt on stderr so stdout only carries results
 	if confirmPassword {
-		fmt.Println("Please enter a password to encrypt messages:")
+		fmt.Fprintln(os.Stderr, "Please enter a password to encrypt messages:")
 	} else {
-		fmt.Println("Please enter the password to decrypt messages:")
+		fmt.Fprintln(os.Stderr, "Please enter the password to decrypt messages:")
 	}
-	fmt.Print("Password: ")
+	fmt.Fprint(os.Stderr, "Password: ")
 	keyBytes, err := term.ReadPassword(int(syscall.Stdin))
-	fmt.Println() // Add newline after password input
+	fmt.Fprintln(os.Stderr) // Add newline after password input
 	if err != nil {
 		return "", fmt.Errorf("error reading password: %v", err)
 	}
 
+	// Reject empty and weak passwords before asking for confirmation
+	if err := ValidatePassword(string(keyBytes)); err != nil {
+		return "", err
+	}
+
 	if confirmPassword {
 		// Confirm password
-		fmt.Print("Confirm password: ")
+		fmt.Fprint(os.Stderr, "Confirm password: ")
 		confirmBytes, err := term.ReadPassword(int(syscall.Stdin))
-		fmt.Println() // Add newline after password input
+		fmt.Fprintln(os.Stderr) // Add newline after password input
 		if err != nil {
 			return "", fmt.Errorf("error reading password confirmation: %v", err)
 		}
@@ -51,11 +155,140 @@ func GetEncryptionKey(confirmPassword bool) (string, error) {
 	return PadKey(string(keyBytes)), nil
 }
 
-// PadKey ensures the key is exactly 32 bytes by padding or truncating
+// ValidatePassword rejects empty, short, common and low-entropy passwords
+func ValidatePassword(password string) error {
+	if password == "" {
+		return fmt.Errorf("empty password is not allowed (use --insecure-no-password to run without one)")
+	}
+
+	if len([]rune(password)) < MIN_PASSWORD_LENGTH {
+		return fmt.Errorf("password must be at least %d characters long", MIN_PASSWORD_LENGTH)
+	}
+
+	if commonPasswords[strings.ToLower(password)] {
+		return fmt.Errorf("password is too common")
+	}
+
+	if bits := PasswordStrength(password); bits < MIN_PASSWORD_ENTROPY {
+		return fmt.Errorf("password is too weak (estimated %.0f bits, need %d): use a longer password, mix letters, digits and symbols and avoid sequences like abcd or qwerty", bits, MIN_PASSWORD_ENTROPY)
+	}
+
+	return nil
+}
+
+// PasswordStrength estimates the entropy of a password in bits.
+// Each new character contributes log2 of the character pool size. Repeated characters, and characters
+// that continue an alphabetical or numeric sequence or a walk along a keyboard row, count as one bit.
+func PasswordStrength(password string) float64 {
+	var hasLower, hasUpper, hasDigit, hasOther bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasOther = true
+		}
+	}
+
+	// Size of the character pool an attacker would have to search
+	pool := 0
+	if hasLower {
+		pool += 26
+	}
+	if hasUpper {
+		pool += 26
+	}
+	if hasDigit {
+		pool += 10
+	}
+	if hasOther {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	seen := make(map[rune]bool)
+	bits := 0.0
+	previous := rune(-1)
+	for _, r := range password {
+		if seen[r] || follows(previous, r) {
+			bits++
+		} else {
+			bits += perChar
+		}
+		seen[r] = true
+		previous = r
+	}
+	return bits
+}
+
+// follows reports whether r comes right before or after previous in the alphabet,
+// among the digits or on a keyboard row, ignoring case
+func follows(previous, r rune) bool {
+	previous, r = unicode.ToLower(previous), unicode.ToLower(r)
+	if r-previous == 1 || previous-r == 1 {
+		if unicode.IsLetter(previous) && unicode.IsLetter(r) || unicode.IsDigit(previous) && unicode.IsDigit(r) {
+			return true
+		}
+	}
+	for _, row := range keyboardRows {
+		i, j := strings.IndexRune(row, previous), strings.IndexRune(row, r)
+		if i >= 0 && j >= 0 && (i-j == 1 || j-i == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint returns a short, printable digest of a (padded) key, like "3f2a:9c01:77de:b540".
+// Both sides can compare it to check they use the same password without revealing it.
+func Fingerprint(key string) string {
+	sum := sha256.Sum256([]byte("local-share fingerprint:" + key))
+	digest := hex.EncodeToString(sum[:FINGERPRINT_BYTES])
+	groups := make([]string, 0, len(digest)/4)
+	for i := 0; i < len(digest); i += 4 {
+		groups = append(groups, digest[i:i+4])
+	}
+	return strings.Join(groups, ":")
+}
+
+// NormalizeFingerprint lowercases a fingerprint and removes separators so it can be compared
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'f':
+			return r
+		case r >= 'A' && r <= 'F':
+			return r - 'A' + 'a'
+		}
+		return -1
+	}, fingerprint)
+}
+
+// ValidateFingerprint rejects text that is not a fingerprint printed by Fingerprint
+func ValidateFingerprint(fingerprint string) error {
+	for _, r := range strings.ToLower(fingerprint) {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r == ':' || r == ' ') {
+			return fmt.Errorf("invalid fingerprint %q (expected hex digits like 3f2a:9c01:77de:b540)", fingerprint)
+		}
+	}
+	if len(NormalizeFingerprint(fingerprint)) != 2*FINGERPRINT_BYTES {
+		return fmt.Errorf("invalid fingerprint %q (expected %d hex digits)", fingerprint, 2*FINGERPRINT_BYTES)
+	}
+	return nil
+}
+
+// PadKey ensures the key is exactly 32 bytes by padding or truncating.
+// An empty key stays empty so that it fails when used as an AES key.
 func PadKey(key string) string {
 	if len(key) == 0 {
-		// If empty, use a default key
-		return "default-32-byte-key-for-local-share!!"
+		return ""
 	}
 
 	if len(key) >= 32 {
@@ -94,6 +327,81 @@ func Encrypt(plaintext []byte, key []byte) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
+// EncryptedSize returns the length of the payload Encrypt produces for plainLen bytes
+func EncryptedSize(plainLen int64) int64 {
+	return (plainLen + aes.BlockSize + 2) / 3 * 4
+}
+
+// PlaintextSize returns the size of the plaintext carried by an encrypted payload of encodedLen bytes.
+// Base64 padding is not known in advance, so the result may exceed the real size by up to two bytes.
+func PlaintextSize(encodedLen int64) int64 {
+	size := encodedLen/4*3 - aes.BlockSize
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// encryptWriter encrypts and base64 encodes everything written to it
+type encryptWriter struct {
+	stream  cipher.Stream
+	encoder io.WriteCloser
+	buffer  []byte
+}
+
+// NewEncryptWriter returns a writer that produces the same payload as Encrypt while the
+// plaintext is written, so large files never have to be held in memory.
+// Close must be called to flush the final base64 block.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate a random IV and send it first
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := encoder.Write(iv); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{stream: cipher.NewCFBEncrypter(block, iv), encoder: encoder}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if cap(w.buffer) < len(p) {
+		w.buffer = make([]byte, len(p))
+	}
+	buffer := w.buffer[:len(p)]
+	w.stream.XORKeyStream(buffer, p)
+	return w.encoder.Write(buffer)
+}
+
+func (w *encryptWriter) Close() error {
+	return w.encoder.Close()
+}
+
+// NewDecryptReader returns a reader that decrypts a payload produced by Encrypt while it is read,
+// so large files never have to be held in memory
+func NewDecryptReader(encrypted io.Reader, key []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract IV
+	decoded := base64.NewDecoder(base64.StdEncoding, encrypted)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(decoded, iv); err != nil {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	return &cipher.StreamReader{S: cipher.NewCFBDecrypter(block, iv), R: decoded}, nil
+}
+
 // Decrypt decrypts ciphertext with AES-256
 func Decrypt(encryptedMsg string, key []byte) (string, error) {
 	ciphertext, err := base64.StdEncoding.DecodeString(encryptedMsg)
@@ -120,4 +428,4 @@ func Decrypt(encryptedMsg string, key []byte) (string, error) {
 	stream.XORKeyStream(ciphertext, ciphertext)
 
 	return string(ciphertext), nil
-} 
\ No newline at end of file
+}
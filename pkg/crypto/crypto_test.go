@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string // Part of the error, empty if the password is accepted
+	}{
+		{"empty", "", "empty password"},
+		{"one short", "Ab1!xyz", "at least 8 characters"},
+		{"short in bytes only", "abcdéfg", "at least 8 characters"}, // 8 bytes, 7 characters
+		{"minimum length", "Am1!xrz9", ""},
+		{"common", "password123", "too common"},
+		{"common in other case", "PassWord1", "too common"},
+		{"lowercase one below entropy", "kqzmxrpvj", "too weak"},
+		{"lowercase at entropy", "kqzmxrpvjt", ""},
+		{"repeated characters", "aaaaaaaaaaaaaaaa", "too weak"},
+		{"alphabetical sequence", "abcdefghij", "too weak"},
+		{"backwards sequence", "zyxwvutsrqponm", "too weak"},
+		{"numeric sequence", "12345678901234", "too weak"},
+		{"sequence in mixed case", "AbCdEfGhIjKl", "too weak"},
+		{"keyboard walk", "ertyuiop[]", "too weak"},
+		{"keyboard walk with symbols", "asdfghjkl;'", "too weak"},
+		{"shifted keyboard walk", "!@#$%^&*()_+", "too weak"},
+		{"digits then letters in order", "1234567890abcdef", "too weak"},
+		{"mixed classes", "Str0ng-Passw0rd!", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidatePassword(test.password)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("ValidatePassword(%q) = %v, want nil", test.password, err)
+			case test.wantErr != "" && err == nil:
+				t.Errorf("ValidatePassword(%q) = nil, want error containing %q", test.password, test.wantErr)
+			case test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr):
+				t.Errorf("ValidatePassword(%q) = %v, want error containing %q", test.password, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	lower, digits, alnum, all := math.Log2(26), math.Log2(36), math.Log2(62), math.Log2(95)
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"a", lower},
+		{"aa", lower + 1},
+		{"amz", 3 * lower},
+		{"amz1", 4 * digits},
+		{"aM1", 3 * alnum},
+		{"aM1!", 4 * all},
+		{"aM1!aM1!", 4*all + 4},
+		{"abc", lower + 2},
+		{"cba", lower + 2},
+		{"aBcD", math.Log2(52) + 3},
+		{"qwerty", lower + 5},
+		{"zxcv", lower + 3},
+		{"9876", math.Log2(10) + 3},
+		{"1357", 4 * math.Log2(10)},
+		{"az", 2 * lower},
+	}
+	for _, test := range tests {
+		if got := PasswordStrength(test.password); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("PasswordStrength(%q) = %.3f, want %.3f", test.password, got, test.want)
+		}
+	}
+}
+
+func TestInsecurePasswordIsAccepted(t *testing.T) {
+	// --insecure-no-password skips validation, but the key must still work
+	key, err := GetEncryptionKeyFrom("", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != PadKey(INSECURE_PASSWORD) || len(key) != 32 {
+		t.Errorf("insecure key = %q, want the padded INSECURE_PASSWORD", key)
+	}
+}
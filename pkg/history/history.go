@@ -0,0 +1,185 @@
+package history
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"local-share/pkg/config"
+)
+
+const (
+	HISTORY_FILE = "history.jsonl"
+	MAX_RECORD   = 16 * 1024 * 1024 // Longest line read back, large enough for the biggest text message
+
+	// Record types
+	TYPE_TEXT = "text"
+	TYPE_FILE = "file"
+)
+
+// ErrNotFound is returned by Get when no record has the ID
+var ErrNotFound = errors.New("no such record")
+
+// Record is one received message or file
+type Record struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"` // TYPE_TEXT or TYPE_FILE
+	Device     string    `json:"device"`
+	Remote     string    `json:"remote"`
+	Text       string    `json:"text,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Bytes      int64     `json:"bytes"`
+	SHA256     string    `json:"sha256,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+}
+
+// Matches reports whether query occurs in the text, filename or sender of r, ignoring case
+func (r Record) Matches(query string) bool {
+	query = strings.ToLower(query)
+	for _, field := range []string{r.Text, r.Name, r.Device, r.Remote} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter selects records in List. Zero fields select everything.
+type Filter struct {
+	Type  string    // Only records of this type
+	Query string    // Only records matching this (see Record.Matches)
+	Since time.Time // Only records at or after this time
+	Limit int       // Only the newest Limit records
+}
+
+// Store keeps records in a JSON Lines file, one record per line, oldest first
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Path returns the default history file, history.jsonl in the data directory
+func Path() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, HISTORY_FILE), nil
+}
+
+// Open returns the store kept in the file at path. The file is created by the first Add.
+func Open(path string) *Store {
+	return &Store{path: path}
+}
+
+// Add appends a record, filling in its ID and time if they are empty.
+// The file is readable only by its owner because it holds the received messages.
+func (s *Store) Add(record Record) (Record, error) {
+	if record.ID == "" {
+		record.ID = newID()
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return record, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return record, err
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return record, err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return record, err
+	}
+	return record, file.Close()
+}
+
+// List returns the records selected by filter, oldest first.
+// Lines that cannot be parsed, such as one cut short by a crash, are skipped.
+func (s *Store) List(filter Filter) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), MAX_RECORD)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if filter.Type != "" && record.Type != filter.Type {
+			continue
+		}
+		if !filter.Since.IsZero() && record.Time.Before(filter.Since) {
+			continue
+		}
+		if filter.Query != "" && !record.Matches(filter.Query) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.path, err)
+	}
+
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[len(records)-filter.Limit:]
+	}
+	return records, nil
+}
+
+// Get returns the record whose ID starts with prefix. The prefix must be unambiguous.
+func (s *Store) Get(prefix string) (Record, error) {
+	records, err := s.List(Filter{})
+	if err != nil {
+		return Record{}, err
+	}
+	var found []Record
+	for _, record := range records {
+		if strings.HasPrefix(record.ID, prefix) {
+			found = append(found, record)
+		}
+	}
+	switch {
+	case prefix == "" || len(found) == 0:
+		return Record{}, fmt.Errorf("%w: %q", ErrNotFound, prefix)
+	case len(found) > 1:
+		return Record{}, fmt.Errorf("record ID %q is ambiguous (%d matches)", prefix, len(found))
+	}
+	return found[0], nil
+}
+
+// newID returns a short random record ID
+func newID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,143 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// testStore returns a store holding records, one minute apart starting at start
+func testStore(t *testing.T, start time.Time, records ...Record) *Store {
+	t.Helper()
+	store := Open(filepath.Join(t.TempDir(), "data", HISTORY_FILE))
+	for i, record := range records {
+		record.Time = start.Add(time.Duration(i) * time.Minute)
+		if _, err := store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestList(t *testing.T) {
+	start := time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)
+	store := testStore(t, start,
+		Record{ID: "a1", Type: TYPE_TEXT, Device: "laptop", Text: "Meeting at noon"},
+		Record{ID: "b2", Type: TYPE_FILE, Device: "desk", Name: "report.pdf", Bytes: 1024},
+		Record{ID: "c3", Type: TYPE_TEXT, Device: "desk", Text: "lunch?"},
+		Record{ID: "d4", Type: TYPE_FILE, Device: "Phone", Name: "photo.jpg", Remote: "192.168.1.40:51000"},
+	)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"a1", "b2", "c3", "d4"}},
+		{"texts", Filter{Type: TYPE_TEXT}, []string{"a1", "c3"}},
+		{"files", Filter{Type: TYPE_FILE}, []string{"b2", "d4"}},
+		{"query in text", Filter{Query: "MEETING"}, []string{"a1"}},
+		{"query in name", Filter{Query: ".pdf"}, []string{"b2"}},
+		{"query in sender", Filter{Query: "desk"}, []string{"b2", "c3"}},
+		{"query in address", Filter{Query: "192.168.1.40"}, []string{"d4"}},
+		{"since", Filter{Since: start.Add(2 * time.Minute)}, []string{"c3", "d4"}},
+		{"limit keeps the newest", Filter{Limit: 3}, []string{"b2", "c3", "d4"}},
+		{"limit larger than the history", Filter{Limit: 10}, []string{"a1", "b2", "c3", "d4"}},
+		{"limit after filtering", Filter{Type: TYPE_TEXT, Limit: 1}, []string{"c3"}},
+		{"no match", Filter{Query: "nothing"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := store.List(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, record := range records {
+				ids = append(ids, record.ID)
+			}
+			// Records come oldest first, so the newest is printed last
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("List(%+v) = %v, want %v", test.filter, ids, test.want)
+			}
+		})
+	}
+}
+
+func TestListSkipsBrokenLines(t *testing.T) {
+	store := testStore(t, time.Now(), Record{ID: "a1", Type: TYPE_TEXT, Text: "kept"})
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"id":"b2","type":"te` + "\n")
+	file.Close()
+	if _, err := store.Add(Record{ID: "c3", Type: TYPE_TEXT}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.List(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != "a1" || records[1].ID != "c3" {
+		t.Errorf("List = %+v, want a1 and c3", records)
+	}
+}
+
+func TestGet(t *testing.T) {
+	store := testStore(t, time.Now(),
+		Record{ID: "ab12", Type: TYPE_TEXT, Text: "first"},
+		Record{ID: "ab34", Type: TYPE_TEXT, Text: "second"},
+		Record{ID: "cd56", Type: TYPE_FILE, Name: "report.pdf"},
+	)
+
+	if record, err := store.Get("cd"); err != nil || record.Name != "report.pdf" {
+		t.Errorf("Get(cd) = %+v, %v; want report.pdf", record, err)
+	}
+	if record, err := store.Get("ab34"); err != nil || record.Text != "second" {
+		t.Errorf("Get(ab34) = %+v, %v; want second", record, err)
+	}
+	if _, err := store.Get("ab"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(ab) = %v, want an ambiguous ID", err)
+	}
+	for _, prefix := range []string{"ff", ""} {
+		if _, err := store.Get(prefix); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want %v", prefix, err, ErrNotFound)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	store := Open(filepath.Join(t.TempDir(), "data", HISTORY_FILE))
+	if records, err := store.List(Filter{}); err != nil || records != nil {
+		t.Fatalf("List before the first Add = %v, %v; want nothing", records, err)
+	}
+
+	before := time.Now()
+	record, err := store.Add(Record{Type: TYPE_TEXT, Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.ID) != 8 || record.Time.Before(before) {
+		t.Errorf("Add filled in ID %q and time %v", record.ID, record.Time)
+	}
+	if got, err := store.Get(record.ID); err != nil || got.Text != "hello" || !got.Time.Equal(record.Time) {
+		t.Errorf("Get = %+v, %v; want the added record", got, err)
+	}
+
+	// Received messages are private
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(store.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("history file mode = %v, want 0600", mode)
+		}
+	}
+}
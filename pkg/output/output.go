@@ -0,0 +1,75 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Fields are the details of an event, encoded as JSON object members
+type Fields map[string]any
+
+// Printer writes what a command does either as human readable lines or,
+// in JSON mode, as one JSON object per event so scripts can parse the outcome
+type Printer struct {
+	out  io.Writer
+	json bool
+
+	mu sync.Mutex
+}
+
+// New creates a printer writing to out
+func New(out io.Writer, json bool) *Printer {
+	return &Printer{out: out, json: json}
+}
+
+// Stdout is the printer used when a command is not given one
+var Stdout = New(os.Stdout, false)
+
+// JSON reports whether events are printed as JSON objects
+func (p *Printer) JSON() bool {
+	return p.json
+}
+
+// Event prints the message formatted from format and args for humans, or a JSON object
+// with "event" set to name, a "time" and fields in JSON mode
+func (p *Printer) Event(name string, fields Fields, format string, args ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.json {
+		fmt.Fprintf(p.out, format, args...)
+		return
+	}
+
+	object := make(Fields, len(fields)+2)
+	for key, value := range fields {
+		object[key] = value
+	}
+	object["event"] = name
+	object["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(object)
+	if err != nil {
+		line, _ = json.Marshal(Fields{"event": "error", "error": err.Error()})
+	}
+	p.out.Write(append(line, '\n'))
+}
+
+// Message prints an informational line, as a "message" event in JSON mode
+func (p *Printer) Message(format string, args ...any) {
+	p.Event("message", Fields{"message": fmt.Sprintf(format, args...)}, format+"\n", args...)
+}
+
+// Warning prints a warning, as a "warning" event in JSON mode
+func (p *Printer) Warning(format string, args ...any) {
+	p.Event("warning", Fields{"message": fmt.Sprintf(format, args...)}, "WARNING: "+format+"\n", args...)
+}
+
+// Error prints a failure, as an "error" event in JSON mode. context describes what failed.
+func (p *Printer) Error(context string, err error) {
+	p.Event("error", Fields{"context": context, "error": err.Error()}, "%s: %v\n", context, err)
+}
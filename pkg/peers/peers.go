@@ -0,0 +1,159 @@
+package peers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"local-share/pkg/config"
+)
+
+const (
+	PEERS_FILE = "peers.json"
+)
+
+// ErrNotFound is returned when the address book has no peer with the given name
+var ErrNotFound = errors.New("no such peer")
+
+// Peer is a named receiver
+type Peer struct {
+	Name        string   `json:"name"`
+	Address     string   `json:"address"`               // "host" or "host:port"
+	Fingerprint string   `json:"fingerprint,omitempty"` // Expected fingerprint of the shared key
+	KeySource   string   `json:"key_source,omitempty"`  // Where the password for this peer comes from
+	Groups      []string `json:"groups,omitempty"`      // Groups the peer belongs to, for sending to all of them
+}
+
+// Book is the address book, stored as JSON. When sending, its names take precedence
+// over the [peers] table of the config file.
+type Book struct {
+	path  string
+	peers map[string]Peer
+}
+
+// Path returns the default address book file, peers.json in the config directory
+func Path() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, PEERS_FILE), nil
+}
+
+// Load reads the address book at path. A missing file is an empty book.
+func Load(path string) (*Book, error) {
+	book := &Book{path: path, peers: make(map[string]Peer)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Peer
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, peer := range list {
+		book.peers[peer.Name] = peer
+	}
+	return book, nil
+}
+
+// Get returns the peer called name
+func (b *Book) Get(name string) (Peer, bool) {
+	peer, ok := b.peers[name]
+	return peer, ok
+}
+
+// List returns the peers sorted by name
+func (b *Book) List() []Peer {
+	list := make([]Peer, 0, len(b.peers))
+	for _, peer := range b.peers {
+		list = append(list, peer)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Group returns the peers in the group called name, sorted by name
+func (b *Book) Group(name string) []Peer {
+	var group []Peer
+	for _, peer := range b.List() {
+		if slices.Contains(peer.Groups, name) {
+			group = append(group, peer)
+		}
+	}
+	return group
+}
+
+// Add stores peer, replacing one with the same name. It reports whether a peer was replaced.
+func (b *Book) Add(peer Peer) (bool, error) {
+	if err := ValidateName(peer.Name); err != nil {
+		return false, err
+	}
+	if peer.Address == "" {
+		return false, fmt.Errorf("peer %s needs an address", peer.Name)
+	}
+	for _, group := range peer.Groups {
+		if err := ValidateName(group); err != nil {
+			return false, fmt.Errorf("invalid group: %v", err)
+		}
+	}
+	_, replaced := b.peers[peer.Name]
+	b.peers[peer.Name] = peer
+	return replaced, nil
+}
+
+// Remove deletes the peer called name
+func (b *Book) Remove(name string) error {
+	if _, ok := b.peers[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	delete(b.peers, name)
+	return nil
+}
+
+// Save writes the address book, replacing the file in one step
+func (b *Book) Save() error {
+	data, err := json.MarshalIndent(b.List(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(b.path), "."+PEERS_FILE+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(append(data, '\n')); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), b.path)
+}
+
+// ValidateName rejects names that are empty or could be mistaken for an address or a port
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("peer name is empty")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return fmt.Errorf("invalid peer name %q (use letters, digits, '-' and '_')", name)
+		}
+	}
+	return nil
+}
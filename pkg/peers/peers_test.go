@@ -0,0 +1,156 @@
+package peers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local-share", PEERS_FILE)
+	book, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := book.List(); len(list) != 0 {
+		t.Fatalf("missing file loaded as %v, want an empty book", list)
+	}
+
+	saved := []Peer{
+		{Name: "desk", Address: "192.168.1.20:9000", Fingerprint: "edd3:961b:dfaa:8a87"},
+		{Name: "lab1", Address: "192.168.1.31", Groups: []string{"lab", "office"}},
+		{Name: "nas", Address: "192.168.1.5", KeySource: "env:NAS_KEY"},
+	}
+	for _, peer := range []Peer{saved[2], saved[0], saved[1]} {
+		if _, err := book.Add(peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := loaded.List(); !reflect.DeepEqual(list, saved) {
+		t.Errorf("loaded %+v, want %+v", list, saved)
+	}
+	// Saving replaces the file without leaving temporary files behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only %s", len(entries), PEERS_FILE)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load accepted an invalid file")
+	}
+}
+
+func TestAddAndRemove(t *testing.T) {
+	book, err := Load(filepath.Join(t.TempDir(), PEERS_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced, err := book.Add(Peer{Name: "desk", Address: "192.168.1.20"}); err != nil || replaced {
+		t.Fatalf("adding desk = %v, %v; want added", replaced, err)
+	}
+	if replaced, err := book.Add(Peer{Name: "desk", Address: "192.168.1.21", Groups: []string{"lab"}}); err != nil || !replaced {
+		t.Fatalf("adding desk again = %v, %v; want replaced", replaced, err)
+	}
+	if peer, ok := book.Get("desk"); !ok || peer.Address != "192.168.1.21" || len(book.List()) != 1 {
+		t.Errorf("book holds %+v, want only the second desk", book.List())
+	}
+
+	invalid := []Peer{
+		{Name: "", Address: "192.168.1.20"},
+		{Name: "192.168.1.20", Address: "192.168.1.20"},
+		{Name: "desk:9000", Address: "192.168.1.20"},
+		{Name: "my desk", Address: "192.168.1.20"},
+		{Name: "laptop"},
+		{Name: "laptop", Address: "192.168.1.30", Groups: []string{"lab", "the lab"}},
+	}
+	for _, peer := range invalid {
+		if _, err := book.Add(peer); err == nil {
+			t.Errorf("Add(%+v) succeeded, want an error", peer)
+		}
+	}
+	if len(book.List()) != 1 {
+		t.Errorf("invalid peers were added: %+v", book.List())
+	}
+
+	if err := book.Remove("nas"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing a missing peer = %v, want %v", err, ErrNotFound)
+	}
+	if err := book.Remove("desk"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := book.Get("desk"); ok {
+		t.Error("desk still in the book after Remove")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	book, err := Load(filepath.Join(t.TempDir(), PEERS_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range []Peer{
+		{Name: "lab2", Address: "192.168.1.32", Groups: []string{"lab"}},
+		{Name: "desk", Address: "192.168.1.20", Groups: []string{"office"}},
+		{Name: "lab1", Address: "192.168.1.31", Groups: []string{"office", "lab"}},
+	} {
+		if _, err := book.Add(peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		group string
+		want  []string
+	}{
+		{"lab", []string{"lab1", "lab2"}},
+		{"office", []string{"desk", "lab1"}},
+		{"kitchen", nil},
+	}
+	for _, test := range tests {
+		var names []string
+		for _, peer := range book.Group(test.group) {
+			names = append(names, peer.Name)
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("Group(%q) = %v, want %v", test.group, names, test.want)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"desk", true},
+		{"Lab-1_b", true},
+		{"", false},
+		{"192.168.1.20", false},
+		{"desk:9000", false},
+		{"[::1]", false},
+		{"my desk", false},
+		{"büro", false},
+		{"a/b", false},
+	}
+	for _, test := range tests {
+		if err := ValidateName(test.name); (err == nil) != test.valid {
+			t.Errorf("ValidateName(%q) = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	BAR_WIDTH       = 30
+	REDRAW_INTERVAL = 100 * time.Millisecond
+)
+
+// Reporter receives progress updates for one transfer
+type Reporter interface {
+	// Update is called with the number of bytes transferred so far
+	Update(done int64)
+	// Finish is called once when the transfer ends, successfully or not
+	Finish()
+}
+
+// Factory creates a Reporter for a transfer of total bytes named name
+type Factory func(name string, total int64) Reporter
+
+// IsTerminal reports whether f is connected to a terminal, so progress bars make sense
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Reader counts the bytes read through it and reports them
+type Reader struct {
+	R        io.Reader
+	Reporter Reporter
+	done     int64
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	r.done += int64(n)
+	r.Reporter.Update(r.done)
+	return n, err
+}
+
+// Writer counts the bytes written through it and reports them
+type Writer struct {
+	W        io.Writer
+	Reporter Reporter
+	done     int64
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.W.Write(p)
+	w.done += int64(n)
+	w.Reporter.Update(w.done)
+	return n, err
+}
+
+// Bar draws a progress bar with bytes, percentage, speed and ETA on a terminal
+type Bar struct {
+	out     io.Writer
+	name    string
+	total   int64
+	started time.Time
+
+	mu       sync.Mutex
+	done     int64
+	lastDraw time.Time
+	finished bool
+}
+
+// NewBar creates a progress bar writing to out
+func NewBar(out io.Writer, name string, total int64) *Bar {
+	return &Bar{out: out, name: name, total: total, started: time.Now()}
+}
+
+// TerminalFactory returns a Factory drawing bars on out, or nil when out is not a terminal
+func TerminalFactory(out *os.File) Factory {
+	if !IsTerminal(out) {
+		return nil
+	}
+	return func(name string, total int64) Reporter {
+		return NewBar(out, name, total)
+	}
+}
+
+// Update implements Reporter. Redraws are limited to a few per second.
+func (b *Bar) Update(done int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.done = done
+	if time.Since(b.lastDraw) >= REDRAW_INTERVAL {
+		b.draw()
+	}
+}
+
+// Finish implements Reporter
+func (b *Bar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.finished {
+		return
+	}
+	b.finished = true
+	b.draw()
+	fmt.Fprintln(b.out)
+}
+
+func (b *Bar) draw() {
+	b.lastDraw = time.Now()
+	elapsed := time.Since(b.started).Seconds()
+
+	percent := 100.0
+	if b.total > 0 {
+		percent = float64(b.done) / float64(b.total) * 100
+	}
+	filled := int(percent / 100 * BAR_WIDTH)
+	filled = min(max(filled, 0), BAR_WIDTH)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", BAR_WIDTH-filled)
+	if filled > 0 && filled < BAR_WIDTH {
+		bar = bar[:filled-1] + ">" + bar[filled:]
+	}
+
+	speed := 0.0
+	if elapsed > 0 {
+		speed = float64(b.done) / elapsed
+	}
+	eta := "--"
+	if b.done >= b.total {
+		eta = "0s"
+	} else if speed > 0 {
+		eta = formatDuration(time.Duration(float64(b.total-b.done) / speed * float64(time.Second)))
+	}
+
+	fmt.Fprintf(b.out, "\r%s %3.0f%% [%s] %s / %s  %s/s  ETA %s\033[K",
+		b.name, percent, bar, FormatSize(b.done), FormatSize(b.total), FormatSize(int64(speed)), eta)
+}
+
+// FormatSize renders a byte count in human readable units
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDuration renders an ETA such as "45s", "3m12s" or "1h05m"
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// Shared adds up the progress of the parts of one transfer sent over several connections
+type Shared struct {
+	reporter Reporter
+
+	mu    sync.Mutex
+	parts []int64
+	done  int64
+}
+
+// NewShared returns a Shared reporting the sum of its parts to reporter
+func NewShared(reporter Reporter) *Shared {
+	return &Shared{reporter: reporter}
+}
+
+// Part returns the Reporter for one part. Finishing a part does not finish the transfer.
+func (s *Shared) Part() Reporter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parts = append(s.parts, 0)
+	return &sharedPart{shared: s, index: len(s.parts) - 1}
+}
+
+// Finish finishes the reporter of the whole transfer
+func (s *Shared) Finish() {
+	s.reporter.Finish()
+}
+
+// sharedPart is the Reporter of one part of a Shared
+type sharedPart struct {
+	shared *Shared
+	index  int
+}
+
+func (p *sharedPart) Update(done int64) {
+	s := p.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done += done - s.parts[p.index]
+	s.parts[p.index] = done
+	s.reporter.Update(s.done)
+}
+
+func (p *sharedPart) Finish() {}
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// VERSION is the protocol version spoken here. Versions before 2 have no hello exchange:
+	// in version 0 the sender sent the transfer right away and the receiver never replied,
+	// version 1 added FROM before the transfer and the ACCEPT, REJECT and DONE replies.
+	VERSION      = 2
+	MIN_VERSION  = 2 // Oldest version spoken after a hello exchange
+	FROM_VERSION = 1 // Version of peers that start with FROM instead of HELLO
+
+	// HELLO is the first line of a connection, before FROM: the sender sends its Hello as JSON,
+	// the receiver answers with HELLO and the Agreement as JSON, or with REJECT
+	HELLO_PREFIX = "HELLO:"
+
+	COMPRESSION_NONE = "none"
+
+	// Features a receiver offers, so the sender knows what it may ask for
+	FEATURE_TEXT     = "text"     // TEXT messages
+	FEATURE_FILE     = "file"     // FILE uploads
+	FEATURE_PARALLEL = "parallel" // PARALLEL and RANGE uploads
+	FEATURE_SYNC     = "sync"     // MANIFEST, SYNC and DELETE
+	FEATURE_SHARE    = "share"    // LIST and GET downloads
+)
+
+// Hello is what a peer supports, sent by the sender to open a connection
+type Hello struct {
+	Version     int      `json:"version"`     // Newest protocol version
+	MinVersion  int      `json:"min_version"` // Oldest protocol version
+	Ciphers     []string `json:"ciphers"`     // In order of preference
+	Compression []string `json:"compression"` // In order of preference
+	Features    []string `json:"features"`
+}
+
+// Agreement is what the receiver chose from the sender's Hello
+type Agreement struct {
+	Version     int      `json:"version"`
+	Cipher      string   `json:"cipher"`
+	Compression string   `json:"compression"`
+	Features    []string `json:"features"` // Features the receiver offers
+}
+
+// Supports reports whether the receiver offers feature
+func (a Agreement) Supports(feature string) bool {
+	return slices.Contains(a.Features, feature)
+}
+
+// IncompatibleError is returned when two peers have no protocol version, cipher or compression in common
+type IncompatibleError struct {
+	Reason string
+}
+
+func (e *IncompatibleError) Error() string {
+	return "incompatible peer: " + e.Reason
+}
+
+// NewHello returns the Hello of this version, offering cipher and features
+func NewHello(cipher string, features ...string) Hello {
+	return Hello{
+		Version:     VERSION,
+		MinVersion:  MIN_VERSION,
+		Ciphers:     []string{cipher},
+		Compression: []string{COMPRESSION_NONE},
+		Features:    features,
+	}
+}
+
+// Negotiate picks the newest common version and the sender's preferred cipher and compression
+// that local, the receiver, also supports. It returns an *IncompatibleError if there is none.
+func Negotiate(local, sender Hello) (Agreement, error) {
+	version := min(local.Version, sender.Version)
+	if version < max(local.MinVersion, sender.MinVersion) {
+		return Agreement{}, &IncompatibleError{Reason: fmt.Sprintf("no common protocol version (receiver speaks %s, sender %s)",
+			versionRange(local), versionRange(sender))}
+	}
+	cipher, ok := firstCommon(sender.Ciphers, local.Ciphers)
+	if !ok {
+		return Agreement{}, &IncompatibleError{Reason: fmt.Sprintf("no common cipher (receiver supports %s, sender %s)",
+			listOrNone(local.Ciphers), listOrNone(sender.Ciphers))}
+	}
+	compression, ok := firstCommon(sender.Compression, local.Compression)
+	if !ok {
+		return Agreement{}, &IncompatibleError{Reason: fmt.Sprintf("no common compression (receiver supports %s, sender %s)",
+			listOrNone(local.Compression), listOrNone(sender.Compression))}
+	}
+	return Agreement{Version: version, Cipher: cipher, Compression: compression, Features: local.Features}, nil
+}
+
+// Check verifies that the receiver's agreement only uses what hello offered
+func (hello Hello) Check(agreement Agreement) error {
+	switch {
+	case agreement.Version < hello.MinVersion || agreement.Version > hello.Version:
+		return &IncompatibleError{Reason: fmt.Sprintf("receiver chose protocol version %d (sender speaks %s)", agreement.Version, versionRange(hello))}
+	case !slices.Contains(hello.Ciphers, agreement.Cipher):
+		return &IncompatibleError{Reason: fmt.Sprintf("receiver chose cipher %q (sender supports %s)", agreement.Cipher, listOrNone(hello.Ciphers))}
+	case !slices.Contains(hello.Compression, agreement.Compression):
+		return &IncompatibleError{Reason: fmt.Sprintf("receiver chose compression %q (sender supports %s)", agreement.Compression, listOrNone(hello.Compression))}
+	}
+	return nil
+}
+
+// HelloLine formats a HELLO line carrying v as JSON
+func HelloLine(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return HELLO_PREFIX + string(data), nil
+}
+
+// ParseHello decodes the JSON of a HELLO line into v
+func ParseHello(line string, v any) error {
+	if !strings.HasPrefix(line, HELLO_PREFIX) {
+		return fmt.Errorf("%w: %q instead of HELLO", ErrUnexpectedReply, line)
+	}
+	if err := json.Unmarshal([]byte(line[len(HELLO_PREFIX):]), v); err != nil {
+		return fmt.Errorf("%w: invalid HELLO: %v", ErrUnexpectedReply, err)
+	}
+	return nil
+}
+
+// firstCommon returns the first of preferred that supported contains
+func firstCommon(preferred, supported []string) (string, bool) {
+	for _, item := range preferred {
+		if slices.Contains(supported, item) {
+			return item, true
+		}
+	}
+	return "", false
+}
+
+func versionRange(hello Hello) string {
+	if hello.MinVersion == hello.Version {
+		return fmt.Sprintf("version %d", hello.Version)
+	}
+	return fmt.Sprintf("versions %d to %d", hello.MinVersion, hello.Version)
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	receiver := Hello{Version: 3, MinVersion: 2, Ciphers: []string{"aes-256-cfb", "chacha20"}, Compression: []string{"none", "zstd"},
+		Features: []string{FEATURE_TEXT, FEATURE_FILE}}
+	tests := []struct {
+		name    string
+		sender  Hello
+		want    Agreement
+		wantErr string // Part of the IncompatibleError, empty if they agree
+	}{
+		{"same version", NewHello("aes-256-cfb"), Agreement{Version: 2, Cipher: "aes-256-cfb", Compression: "none", Features: receiver.Features}, ""},
+		{"newest common version", Hello{Version: 5, MinVersion: 1, Ciphers: []string{"aes-256-cfb"}, Compression: []string{"none"}},
+			Agreement{Version: 3, Cipher: "aes-256-cfb", Compression: "none", Features: receiver.Features}, ""},
+		{"sender's preference first", Hello{Version: 2, MinVersion: 2, Ciphers: []string{"chacha20", "aes-256-cfb"}, Compression: []string{"zstd", "none"}},
+			Agreement{Version: 2, Cipher: "chacha20", Compression: "zstd", Features: receiver.Features}, ""},
+		{"unknown preferences skipped", Hello{Version: 2, MinVersion: 2, Ciphers: []string{"rot13", "aes-256-cfb"}, Compression: []string{"lz4", "none"}},
+			Agreement{Version: 2, Cipher: "aes-256-cfb", Compression: "none", Features: receiver.Features}, ""},
+
+		{"sender too old", Hello{Version: 1, MinVersion: 1, Ciphers: []string{"aes-256-cfb"}, Compression: []string{"none"}}, Agreement{},
+			"no common protocol version (receiver speaks versions 2 to 3, sender version 1)"},
+		{"sender too new", Hello{Version: 9, MinVersion: 4, Ciphers: []string{"aes-256-cfb"}, Compression: []string{"none"}}, Agreement{},
+			"no common protocol version (receiver speaks versions 2 to 3, sender versions 4 to 9)"},
+		{"no common cipher", Hello{Version: 2, MinVersion: 2, Ciphers: []string{"rot13"}, Compression: []string{"none"}}, Agreement{},
+			"no common cipher (receiver supports aes-256-cfb, chacha20, sender rot13)"},
+		{"no ciphers", Hello{Version: 2, MinVersion: 2, Compression: []string{"none"}}, Agreement{}, "sender none"},
+		{"no common compression", Hello{Version: 2, MinVersion: 2, Ciphers: []string{"aes-256-cfb"}, Compression: []string{"lz4"}}, Agreement{},
+			"no common compression"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Negotiate(receiver, test.sender)
+			if test.wantErr != "" {
+				var incompatible *IncompatibleError
+				if !errors.As(err, &incompatible) || !strings.Contains(incompatible.Reason, test.wantErr) {
+					t.Fatalf("Negotiate = %v, want an IncompatibleError containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Negotiate failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Negotiate = %+v, want %+v", got, test.want)
+			}
+			// What the receiver chose must pass the sender's check
+			if err := test.sender.Check(got); err != nil {
+				t.Errorf("Check of the negotiated agreement failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	hello := Hello{Version: 3, MinVersion: 2, Ciphers: []string{"aes-256-cfb"}, Compression: []string{"none"}}
+	tests := []struct {
+		agreement Agreement
+		wantErr   string
+	}{
+		{Agreement{Version: 2, Cipher: "aes-256-cfb", Compression: "none"}, ""},
+		{Agreement{Version: 3, Cipher: "aes-256-cfb", Compression: "none", Features: []string{"future"}}, ""},
+		{Agreement{Version: 1, Cipher: "aes-256-cfb", Compression: "none"}, "protocol version 1 (sender speaks versions 2 to 3)"},
+		{Agreement{Version: 4, Cipher: "aes-256-cfb", Compression: "none"}, "protocol version 4"},
+		{Agreement{Version: 2, Cipher: "rot13", Compression: "none"}, `cipher "rot13"`},
+		{Agreement{Version: 2, Cipher: "", Compression: "none"}, `cipher ""`},
+		{Agreement{Version: 2, Cipher: "aes-256-cfb", Compression: "zstd"}, `compression "zstd"`},
+	}
+	for _, test := range tests {
+		err := hello.Check(test.agreement)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("Check(%+v) = %v, want nil", test.agreement, err)
+			}
+			continue
+		}
+		var incompatible *IncompatibleError
+		if !errors.As(err, &incompatible) || !strings.Contains(incompatible.Reason, test.wantErr) {
+			t.Errorf("Check(%+v) = %v, want an IncompatibleError containing %q", test.agreement, err, test.wantErr)
+		}
+	}
+}
+
+func TestHelloLine(t *testing.T) {
+	hello := NewHello("aes-256-cfb", FEATURE_TEXT, FEATURE_PARALLEL)
+	line, err := HelloLine(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(line, "\n") {
+		t.Errorf("HelloLine(%+v) = %q spans several lines", hello, line)
+	}
+	var parsed Hello
+	if err := ParseHello(line, &parsed); err != nil || !reflect.DeepEqual(parsed, hello) {
+		t.Errorf("ParseHello(HelloLine) = %+v, %v, want %+v", parsed, err, hello)
+	}
+
+	// Fields a newer peer adds are ignored
+	var agreement Agreement
+	if err := ParseHello(`HELLO:{"version":2,"cipher":"aes-256-cfb","compression":"none","window":64}`, &agreement); err != nil || agreement.Version != 2 {
+		t.Errorf("ParseHello with an unknown field = %+v, %v", agreement, err)
+	}
+
+	for _, bad := range []string{"ACCEPT", "REJECT:invalid unknown transfer type", "HELLO:", "HELLO:{", `HELLO:{"version":"2"}`} {
+		if err := ParseHello(bad, &agreement); !errors.Is(err, ErrUnexpectedReply) {
+			t.Errorf("ParseHello(%q) = %v, want ErrUnexpectedReply", bad, err)
+		}
+	}
+}
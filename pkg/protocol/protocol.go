@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Line prefixes exchanged between sender and receiver.
+// Every message is a single line terminated by "\n"; encrypted fields are base64 encoded.
+// LIST and GET are answered like a FILE request in reverse: ACCEPT, a line with the length
+// of the encrypted payload, the payload itself and DONE with its checksum.
+const (
+	FROM_PREFIX   = "FROM:"   // Sender identification, followed by the encrypted device name
+	FILE_PREFIX   = "FILE:"   // File transfer, followed by the encrypted filename
+	TEXT_PREFIX   = "TEXT:"   // Text message, followed by the encrypted text
+	REJECT_PREFIX = "REJECT:" // Receiver refused the transfer, followed by a reject code and the reason
+	ACCEPT        = "ACCEPT"  // Receiver agreed to the transfer
+	DONE_PREFIX   = "DONE:"   // Receiver completed the transfer, followed by an encrypted result
+	LIST_PREFIX   = "LIST:"   // Request for a directory listing of a share, followed by the encrypted directory
+	GET_PREFIX    = "GET:"    // Request for a file of a share, followed by the encrypted path
+
+	// Folder synchronization. Paths start with the sync target, e.g. "build/bin/app".
+	MANIFEST_PREFIX = "MANIFEST:" // Request for the entries below a sync target, answered like LIST
+	SYNC_PREFIX     = "SYNC:"     // Upload replacing the file at a path, followed by the length like FILE
+	DELETE_PREFIX   = "DELETE:"   // Removal of the file at a path, answered with DONE
+
+	// Parallel transfers. PARALLEL is answered with ACCEPT and a line with the encrypted
+	// "transfer ID\nstreams"; the sender then sends every part on its own connection with
+	// RANGE, and the receiver confirms the whole file with DONE on the first connection.
+	PARALLEL_PREFIX = "PARALLEL:" // File transfer over several connections, followed by the encrypted "name\nsize\nstreams"
+	RANGE_PREFIX    = "RANGE:"    // One part of a parallel transfer, followed by the encrypted "transfer ID\npart" and the length like FILE
+
+	// DEVICE_MAGIC prefixes the device name before encryption so the receiver can tell
+	// whether the sender used the same password
+	DEVICE_MAGIC = "local-share:"
+)
+
+// Reject codes tell the sender why a transfer was refused
+const (
+	REJECT_AUTH         = "auth"         // The sender used a different password
+	REJECT_DECLINED     = "declined"     // The user at the receiver said no
+	REJECT_LIMIT        = "limit"        // A size or connection limit was exceeded
+	REJECT_STORAGE      = "storage"      // Not enough disk space or upload quota
+	REJECT_EXISTS       = "exists"       // A file with the same name exists and may not be replaced
+	REJECT_INVALID      = "invalid"      // The request was malformed
+	REJECT_FAILED       = "failed"       // The receiver could not complete the transfer
+	REJECT_INCOMPATIBLE = "incompatible" // No common protocol version, cipher or compression
+	REJECT_UNSUPPORTED  = "unsupported"  // The receiver does not offer this kind of transfer
+)
+
+var (
+	// ErrLineTooLong is returned by ReadLine when a peer sends a line above the allowed size
+	ErrLineTooLong = errors.New("line too long")
+	// ErrUnexpectedReply is returned when a peer answers with something the protocol does not allow
+	ErrUnexpectedReply = errors.New("unexpected reply")
+)
+
+// RejectError is a refusal reported by the receiver
+type RejectError struct {
+	Code   string // One of the REJECT_* codes, empty if the receiver sent none
+	Reason string // Human readable explanation
+}
+
+func (e *RejectError) Error() string {
+	return "transfer rejected by receiver: " + e.Reason
+}
+
+// Entry is one file or directory of a share, or a file of a sync manifest.
+// LIST and MANIFEST replies carry a JSON array of entries.
+// Names use "/" as separator, relative to the top of the share or the sync target.
+type Entry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Dir     bool      `json:"dir,omitempty"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"` // Content checksum, only in manifests
+}
+
+// Part is the byte range of a file sent on one connection of a parallel transfer
+type Part struct {
+	Offset int64
+	Length int64
+}
+
+// SplitParts divides size bytes into streams parts of nearly equal length, in order.
+// Both sides compute the parts, so only the part number travels with RANGE.
+func SplitParts(size int64, streams int) []Part {
+	if streams < 1 {
+		streams = 1
+	}
+	parts := make([]Part, streams)
+	var offset int64
+	for i := range parts {
+		length := size / int64(streams)
+		if int64(i) < size%int64(streams) {
+			length++
+		}
+		parts[i] = Part{Offset: offset, Length: length}
+		offset += length
+	}
+	return parts
+}
+
+// RejectLine formats a rejection reply
+func RejectLine(code, reason string) string {
+	return REJECT_PREFIX + code + " " + reason + "\n"
+}
+
+// ParseReply interprets the receiver's reply to a transfer request.
+// It returns nil when the transfer was accepted and a *RejectError when it was refused.
+func ParseReply(line string) error {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == ACCEPT:
+		return nil
+	case strings.HasPrefix(line, REJECT_PREFIX):
+		return parseReject(line[len(REJECT_PREFIX):])
+	default:
+		return fmt.Errorf("%w from receiver: %q", ErrUnexpectedReply, line)
+	}
+}
+
+// ParseDone interprets the receiver's final reply and returns the (still encrypted) result.
+// A refusal is returned as a *RejectError.
+func ParseDone(line string) (string, error) {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, DONE_PREFIX):
+		return line[len(DONE_PREFIX):], nil
+	case strings.HasPrefix(line, REJECT_PREFIX):
+		return "", parseReject(line[len(REJECT_PREFIX):])
+	default:
+		return "", fmt.Errorf("%w from receiver: %q", ErrUnexpectedReply, line)
+	}
+}
+
+// parseReject splits "<code> <reason>" as written by RejectLine
+func parseReject(rest string) *RejectError {
+	code, reason, found := strings.Cut(rest, " ")
+	switch code {
+	case REJECT_AUTH, REJECT_DECLINED, REJECT_LIMIT, REJECT_STORAGE, REJECT_EXISTS, REJECT_INVALID, REJECT_FAILED, REJECT_INCOMPATIBLE, REJECT_UNSUPPORTED:
+		if found {
+			return &RejectError{Code: code, Reason: reason}
+		}
+		return &RejectError{Code: code, Reason: code}
+	}
+	return &RejectError{Reason: rest}
+}
+
+// ReadLine reads one line of at most maxLen bytes and returns it without surrounding whitespace.
+// Longer lines are rejected instead of being buffered without bound.
+func ReadLine(reader *bufio.Reader, maxLen int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLen {
+			return "", fmt.Errorf("%w: more than %d bytes", ErrLineTooLong, maxLen)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(line)), nil
+	}
+}
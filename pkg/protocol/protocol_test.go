@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSplitParts(t *testing.T) {
+	tests := []struct {
+		size    int64
+		streams int
+	}{
+		{0, 1}, {0, 4}, {1, 1}, {1, 3}, {3, 3}, {7, 3}, {8, 3}, {9, 3},
+		{4 << 20, 4}, {4<<20 + 1, 4}, {4<<20 - 1, 4}, {1 << 40, 32}, {10, 0},
+	}
+	for _, test := range tests {
+		parts := SplitParts(test.size, test.streams)
+		if want := max(test.streams, 1); len(parts) != want {
+			t.Errorf("SplitParts(%d, %d) returned %d parts, want %d", test.size, test.streams, len(parts), want)
+			continue
+		}
+		// The parts follow each other without gaps, cover the whole file and differ by at most a byte
+		var offset int64
+		shortest, longest := parts[0].Length, parts[0].Length
+		for i, part := range parts {
+			if part.Offset != offset {
+				t.Errorf("SplitParts(%d, %d) part %d starts at %d, want %d", test.size, test.streams, i, part.Offset, offset)
+			}
+			if i > 0 && part.Length > parts[i-1].Length {
+				t.Errorf("SplitParts(%d, %d) part %d is longer than the one before", test.size, test.streams, i)
+			}
+			offset += part.Length
+			shortest, longest = min(shortest, part.Length), max(longest, part.Length)
+		}
+		if offset != test.size {
+			t.Errorf("SplitParts(%d, %d) covers %d bytes", test.size, test.streams, offset)
+		}
+		if longest-shortest > 1 {
+			t.Errorf("SplitParts(%d, %d) parts range from %d to %d bytes", test.size, test.streams, shortest, longest)
+		}
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantCode   string
+		wantReason string
+		accepted   bool
+	}{
+		{"ACCEPT", "", "", true},
+		{"ACCEPT\r\n", "", "", true},
+		{"REJECT:auth wrong password", REJECT_AUTH, "wrong password", false},
+		{"REJECT:limit file too large (max 8 B)", REJECT_LIMIT, "file too large (max 8 B)", false},
+		{"REJECT:incompatible no common cipher", REJECT_INCOMPATIBLE, "no common cipher", false},
+		{"REJECT:unsupported this receiver does not support parallel transfers", REJECT_UNSUPPORTED, "this receiver does not support parallel transfers", false},
+		{"REJECT:declined", REJECT_DECLINED, REJECT_DECLINED, false}, // Without a reason the code is the reason
+		// Receivers without reject codes send only a reason
+		{"REJECT:file too large", "", "file too large", false},
+	}
+	for _, test := range tests {
+		err := ParseReply(test.line)
+		if test.accepted {
+			if err != nil {
+				t.Errorf("ParseReply(%q) = %v, want nil", test.line, err)
+			}
+			continue
+		}
+		var reject *RejectError
+		if !errors.As(err, &reject) {
+			t.Errorf("ParseReply(%q) = %v, want a RejectError", test.line, err)
+			continue
+		}
+		if reject.Code != test.wantCode || reject.Reason != test.wantReason {
+			t.Errorf("ParseReply(%q) = code %q reason %q, want %q and %q", test.line, reject.Code, reject.Reason, test.wantCode, test.wantReason)
+		}
+	}
+
+	if err := ParseReply("DONE:abc"); !errors.Is(err, ErrUnexpectedReply) {
+		t.Errorf("ParseReply(DONE) = %v, want ErrUnexpectedReply", err)
+	}
+}
+
+func TestRejectLineRoundTrip(t *testing.T) {
+	line := RejectLine(REJECT_UNSUPPORTED, "no parallel transfers")
+	var reject *RejectError
+	if err := ParseReply(line); !errors.As(err, &reject) || reject.Code != REJECT_UNSUPPORTED || reject.Reason != "no parallel transfers" {
+		t.Errorf("ParseReply(RejectLine(...)) = %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	MIN_BURST = 32 * 1024 // Smallest amount of data read or written at once
+)
+
+// Limiter is a token bucket allowing a number of bytes per second. It is safe for
+// concurrent use, so one Limiter can limit several connections together.
+type Limiter struct {
+	rate  float64 // Bytes per second
+	burst int     // Most bytes that may pass at once
+
+	mu     sync.Mutex
+	tokens float64 // Bytes that may pass now; negative while waiters are owed time
+	last   time.Time
+
+	now   func() time.Time    // Clock, replaced in tests
+	sleep func(time.Duration) // Waits on the clock, replaced in tests
+}
+
+// NewLimiter returns a limiter allowing bytesPerSecond, or nil (no limit) if it is not positive
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	// Let a tenth of a second pass at once so the rate stays smooth
+	burst := max(int(bytesPerSecond/10), MIN_BURST)
+	return &Limiter{rate: float64(bytesPerSecond), burst: burst, tokens: float64(burst), last: time.Now(), now: time.Now, sleep: time.Sleep}
+}
+
+// Rate returns the allowed bytes per second
+func (l *Limiter) Rate() int64 {
+	return int64(l.rate)
+}
+
+// wait blocks until n bytes may pass. n must not exceed the burst.
+func (l *Limiter) wait(n int) {
+	l.mu.Lock()
+	now := l.now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	l.last = now
+	// Take the tokens now, so concurrent callers queue up behind each other
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
+
+// Conn limits the reads and writes of a connection by one or more limiters,
+// for example one of its own and one shared by all connections
+type Conn struct {
+	net.Conn
+	limiters []*Limiter
+}
+
+// NewConn wraps conn so its traffic passes every limiter that is not nil.
+// Without any limiter conn is returned unchanged.
+func NewConn(conn net.Conn, limiters ...*Limiter) net.Conn {
+	var active []*Limiter
+	for _, limiter := range limiters {
+		if limiter != nil {
+			active = append(active, limiter)
+		}
+	}
+	if len(active) == 0 {
+		return conn
+	}
+	return &Conn{Conn: conn, limiters: active}
+}
+
+// chunk returns the most bytes that may pass through all limiters at once
+func (c *Conn) chunk() int {
+	size := c.limiters[0].burst
+	for _, limiter := range c.limiters[1:] {
+		size = min(size, limiter.burst)
+	}
+	return size
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(p) > c.chunk() {
+		p = p[:c.chunk()]
+	}
+	n, err := c.Conn.Read(p)
+	// Pay for what arrived; the sender slows down once the socket buffers fill
+	for _, limiter := range c.limiters {
+		limiter.wait(n)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := min(len(p), c.chunk())
+		for _, limiter := range c.limiters {
+			limiter.wait(size)
+		}
+		n, err := c.Conn.Write(p[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[size:]
+	}
+	return written, nil
+}
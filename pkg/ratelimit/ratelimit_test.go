@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeClock stands still until the test moves it and records the sleeps asked of it
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) { c.sleeps = append(c.sleeps, d) }
+
+// takeSleeps returns the sleeps since the last call
+func (c *fakeClock) takeSleeps() []time.Duration {
+	sleeps := c.sleeps
+	c.sleeps = nil
+	return sleeps
+}
+
+// newTestLimiter returns a limiter of bytesPerSecond running on a fake clock
+func newTestLimiter(bytesPerSecond int64) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := NewLimiter(bytesPerSecond)
+	limiter.now, limiter.sleep, limiter.last = clock.Now, clock.Sleep, clock.now
+	return limiter, clock
+}
+
+func TestNewLimiter(t *testing.T) {
+	for _, rate := range []int64{0, -1} {
+		if limiter := NewLimiter(rate); limiter != nil {
+			t.Errorf("NewLimiter(%d) = %+v, want nil", rate, limiter)
+		}
+	}
+	tests := []struct {
+		rate      int64
+		wantBurst int
+	}{
+		{1, MIN_BURST},
+		{1000, MIN_BURST},
+		{10 * MIN_BURST, MIN_BURST},
+		{10*MIN_BURST + 10, MIN_BURST + 1},
+		{100 << 20, 10 << 20},
+	}
+	for _, test := range tests {
+		limiter := NewLimiter(test.rate)
+		if limiter.burst != test.wantBurst || limiter.Rate() != test.rate {
+			t.Errorf("NewLimiter(%d) has burst %d and rate %d, want burst %d", test.rate, limiter.burst, limiter.Rate(), test.wantBurst)
+		}
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	limiter, clock := newTestLimiter(1000)
+
+	// A full bucket lets the burst pass at once
+	limiter.wait(MIN_BURST)
+	if sleeps := clock.takeSleeps(); len(sleeps) != 0 {
+		t.Errorf("burst slept %v", sleeps)
+	}
+
+	// Callers arriving together queue up behind each other
+	limiter.wait(1000)
+	limiter.wait(500)
+	if sleeps := clock.takeSleeps(); !slices.Equal(sleeps, []time.Duration{time.Second, 1500 * time.Millisecond}) {
+		t.Errorf("queued callers slept %v, want 1s and 1.5s", sleeps)
+	}
+
+	// Time passing pays off the debt
+	clock.now = clock.now.Add(1500 * time.Millisecond)
+	limiter.wait(250)
+	if sleeps := clock.takeSleeps(); !slices.Equal(sleeps, []time.Duration{250 * time.Millisecond}) {
+		t.Errorf("slept %v after the debt was paid, want 250ms", sleeps)
+	}
+
+	// An idle limiter saves up no more than the burst
+	clock.now = clock.now.Add(time.Hour)
+	limiter.wait(MIN_BURST)
+	limiter.wait(1)
+	if sleeps := clock.takeSleeps(); !slices.Equal(sleeps, []time.Duration{time.Millisecond}) {
+		t.Errorf("slept %v after an hour idle, want only 1ms for the byte over the burst", sleeps)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	const rate = 1 << 20
+	limiter, clock := newTestLimiter(rate)
+
+	// A sender as fast as it can be moves ten seconds of data in ten seconds, less the
+	// burst that passes at once
+	var slept time.Duration
+	for sent := 0; sent < 10*rate; sent += 4096 {
+		limiter.wait(4096)
+		for _, sleep := range clock.takeSleeps() {
+			slept += sleep
+			clock.now = clock.now.Add(sleep)
+		}
+	}
+	want := 10*time.Second - time.Duration(limiter.burst)*time.Second/rate
+	if diff := slept - want; diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("slept %v in total, want %v", slept, want)
+	}
+}
+
+// recordingConn records the size of every write
+type recordingConn struct {
+	net.Conn
+	writes []int
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	return len(p), nil
+}
+
+func TestNewConn(t *testing.T) {
+	conn := &recordingConn{}
+	if got := NewConn(conn); got != net.Conn(conn) {
+		t.Errorf("NewConn without limiters = %T, want the connection itself", got)
+	}
+	if got := NewConn(conn, nil, nil); got != net.Conn(conn) {
+		t.Errorf("NewConn with nil limiters = %T, want the connection itself", got)
+	}
+
+	small, smallClock := newTestLimiter(1000)
+	large, largeClock := newTestLimiter(100 << 20)
+	limited := NewConn(conn, nil, large, small)
+	n, err := limited.Write(make([]byte, 2*MIN_BURST+10))
+	if n != 2*MIN_BURST+10 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	// Writes are cut to the smallest burst and pass every limiter
+	if !slices.Equal(conn.writes, []int{MIN_BURST, MIN_BURST, 10}) {
+		t.Errorf("written in chunks of %v, want at most %d bytes each", conn.writes, MIN_BURST)
+	}
+	if sleeps := smallClock.takeSleeps(); len(sleeps) != 2 {
+		t.Errorf("small limiter slept %v, want twice after its burst", sleeps)
+	}
+	if sleeps := largeClock.takeSleeps(); len(sleeps) != 0 {
+		t.Errorf("large limiter slept %v within its burst", sleeps)
+	}
+}
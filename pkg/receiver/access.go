@@ -0,0 +1,124 @@
+package receiver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// AccessList decides which sender addresses may connect.
+// Deny rules always win; when allow rules are present an address must match one of them.
+type AccessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewAccessList builds an access list from IP addresses and CIDR ranges
+func NewAccessList(allow, deny []string) (*AccessList, error) {
+	list := &AccessList{}
+	for _, rule := range allow {
+		network, err := ParseAccessRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		list.allow = append(list.allow, network)
+	}
+	for _, rule := range deny {
+		network, err := ParseAccessRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		list.deny = append(list.deny, network)
+	}
+	return list, nil
+}
+
+// ParseAccessRule parses "192.168.1.0/24" or a single address such as "192.168.1.50"
+func ParseAccessRule(rule string) (*net.IPNet, error) {
+	rule = strings.TrimSpace(rule)
+	if strings.Contains(rule, "/") {
+		_, network, err := net.ParseCIDR(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR rule %q: %v", rule, err)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(rule)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP rule %q", rule)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// LoadAccessRules reads "allow <rule>" and "deny <rule>" lines from a file.
+// Blank lines and lines starting with "#" are ignored.
+func LoadAccessRules(path string) (allow, deny []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s:%d: expected \"allow <rule>\" or \"deny <rule>\"", path, lineNumber)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			allow = append(allow, fields[1])
+		case "deny":
+			deny = append(deny, fields[1])
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: unknown action %q", path, lineNumber, fields[0])
+		}
+	}
+	return allow, deny, scanner.Err()
+}
+
+// Allowed reports whether the given remote address ("ip:port" or "ip") may connect
+func (a *AccessList) Allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range a.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, network := range a.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether the list has no rules at all
+func (a *AccessList) Empty() bool {
+	return len(a.allow) == 0 && len(a.deny) == 0
+}
@@ -0,0 +1,123 @@
+package receiver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAccessListAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny []string
+		addr        string
+		want        bool
+	}{
+		{"empty list", nil, nil, "203.0.113.7:4000", true},
+		{"empty list IPv6", nil, nil, "[2001:db8::1]:4000", true},
+		{"address without port", nil, nil, "203.0.113.7", true},
+		{"unparsable address", nil, nil, "not-an-ip:4000", false},
+
+		{"IPv4 CIDR allowed", []string{"192.168.1.0/24"}, nil, "192.168.1.42:4000", true},
+		{"IPv4 CIDR outside", []string{"192.168.1.0/24"}, nil, "192.168.2.42:4000", false},
+		{"IPv4 bare IP allowed", []string{"192.168.1.50"}, nil, "192.168.1.50:4000", true},
+		{"IPv4 bare IP neighbour", []string{"192.168.1.50"}, nil, "192.168.1.51:4000", false},
+		{"IPv4-mapped IPv6 sender", []string{"192.168.1.0/24"}, nil, "[::ffff:192.168.1.42]:4000", true},
+
+		{"IPv6 CIDR allowed", []string{"2001:db8::/32"}, nil, "[2001:db8:1::5]:4000", true},
+		{"IPv6 CIDR outside", []string{"2001:db8::/32"}, nil, "[2001:db9::5]:4000", false},
+		{"IPv6 bare IP allowed", []string{"fd00::1"}, nil, "[fd00::1]:4000", true},
+		{"IPv6 bare IP neighbour", []string{"fd00::1"}, nil, "[fd00::2]:4000", false},
+		{"IPv4 rule does not match IPv6", []string{"0.0.0.0/0"}, nil, "[2001:db8::1]:4000", false},
+
+		{"deny only", nil, []string{"10.0.0.0/8"}, "10.1.2.3:4000", false},
+		{"deny only other address", nil, []string{"10.0.0.0/8"}, "192.168.1.1:4000", true},
+		{"deny wins over allow", []string{"192.168.1.0/24"}, []string{"192.168.1.66"}, "192.168.1.66:4000", false},
+		{"deny wins over broader allow", []string{"0.0.0.0/0"}, []string{"192.168.0.0/16"}, "192.168.7.7:4000", false},
+		{"allow next to deny", []string{"192.168.1.0/24"}, []string{"192.168.1.66"}, "192.168.1.67:4000", true},
+		{"deny IPv6", []string{"::/0"}, []string{"fe80::/10"}, "[fe80::1]:4000", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, err := NewAccessList(test.allow, test.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := list.Allowed(test.addr); got != test.want {
+				t.Errorf("Allowed(%q) with allow %v, deny %v = %v, want %v", test.addr, test.allow, test.deny, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseAccessRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    string // Network as printed, empty if the rule is invalid
+		wantErr bool
+	}{
+		{"192.168.1.0/24", "192.168.1.0/24", false},
+		{"192.168.1.77/24", "192.168.1.0/24", false}, // Host bits are dropped
+		{" 10.0.0.1 ", "10.0.0.1/32", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"fd00::1", "fd00::1/128", false},
+		{"192.168.1.0/33", "", true},
+		{"192.168.1", "", true},
+		{"lab-laptop", "", true},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		network, err := ParseAccessRule(test.rule)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseAccessRule(%q) = %v, want an error", test.rule, network)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAccessRule(%q) failed: %v", test.rule, err)
+			continue
+		}
+		if got := network.String(); got != test.want {
+			t.Errorf("ParseAccessRule(%q) = %s, want %s", test.rule, got, test.want)
+		}
+	}
+}
+
+func TestNewAccessListInvalidRule(t *testing.T) {
+	if _, err := NewAccessList([]string{"192.168.1.0/24"}, []string{"nonsense"}); err == nil {
+		t.Error("NewAccessList accepted an invalid deny rule")
+	}
+	list, err := NewAccessList(nil, nil)
+	if err != nil || !list.Empty() {
+		t.Errorf("NewAccessList(nil, nil) = %v, %v, want an empty list", list, err)
+	}
+}
+
+func TestLoadAccessRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access")
+	content := "# lab network\nallow 192.168.1.0/24\n\n  DENY 192.168.1.66  \nallow fd00::/8\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	allow, deny, err := LoadAccessRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.168.1.0/24", "fd00::/8"}; !reflect.DeepEqual(allow, want) {
+		t.Errorf("allow = %v, want %v", allow, want)
+	}
+	if want := []string{"192.168.1.66"}; !reflect.DeepEqual(deny, want) {
+		t.Errorf("deny = %v, want %v", deny, want)
+	}
+
+	for _, bad := range []string{"permit 10.0.0.1\n", "allow\n", "allow 10.0.0.1 10.0.0.2\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := LoadAccessRules(path); err == nil {
+			t.Errorf("LoadAccessRules accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package receiver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"local-share/pkg/progress"
+)
+
+// TransferRequest describes an incoming transfer waiting for approval
+type TransferRequest struct {
+	RemoteAddr string   // Address of the sender
+	Device     string   // Device name announced by the sender
+	Files      []string // Names of the files to be received
+	TotalSize  int64    // Total size of the files in bytes
+}
+
+// Approver decides whether an incoming transfer may proceed
+type Approver interface {
+	Approve(req TransferRequest) bool
+}
+
+// ApproverFunc adapts a function to the Approver interface
+type ApproverFunc func(req TransferRequest) bool
+
+func (f ApproverFunc) Approve(req TransferRequest) bool {
+	return f(req)
+}
+
+// PromptApprover asks the user on a terminal about every incoming transfer
+type PromptApprover struct {
+	autoAccept []string // Device name or IP patterns that are accepted without asking
+
+	mu          sync.Mutex // Serializes prompts so only one question is shown at a time
+	input       *bufio.Reader
+	output      io.Writer
+	acceptedAll map[string]bool // Devices and their IPs the user chose "accept all" for
+}
+
+// NewPromptApprover reads answers from input and writes questions to output.
+// Transfers from devices or IPs matching an autoAccept glob pattern are accepted without asking.
+func NewPromptApprover(input io.Reader, output io.Writer, autoAccept []string) *PromptApprover {
+	return &PromptApprover{
+		autoAccept:  autoAccept,
+		input:       bufio.NewReader(input),
+		output:      output,
+		acceptedAll: make(map[string]bool),
+	}
+}
+
+// Approve implements Approver
+func (a *PromptApprover) Approve(req TransferRequest) bool {
+	if a.isTrusted(req) {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Device names are chosen by the sender, so "accept all" also remembers where it came from
+	device := req.Device + "@" + requestHost(req)
+	if a.acceptedAll[device] {
+		return true
+	}
+
+	fmt.Fprintln(a.output)
+	fmt.Fprintln(a.output, "Incoming transfer request:")
+	fmt.Fprintf(a.output, "  From:   %s (%s)\n", req.Device, req.RemoteAddr)
+	for _, name := range req.Files {
+		fmt.Fprintf(a.output, "  File:   %s\n", name)
+	}
+	fmt.Fprintf(a.output, "  Size:   %s\n", progress.FormatSize(req.TotalSize))
+
+	for {
+		fmt.Fprint(a.output, "Accept? [y]es / [n]o / [a]ccept all from this device: ")
+		answer, err := a.input.ReadString('\n')
+		if err != nil {
+			// Without a terminal there is nobody to ask, so refuse
+			fmt.Fprintln(a.output)
+			return false
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "n", "no", "":
+			return false
+		case "a", "all":
+			a.acceptedAll[device] = true
+			return true
+		}
+	}
+}
+
+// isTrusted checks the auto-accept rules against the device name and sender IP
+func (a *PromptApprover) isTrusted(req TransferRequest) bool {
+	host := requestHost(req)
+	for _, pattern := range a.autoAccept {
+		if matched, _ := path.Match(pattern, req.Device); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// requestHost returns the sender IP of req without its port
+func requestHost(req TransferRequest) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package receiver
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the number of bytes available to unprivileged users on the filesystem holding dir
+func freeSpace(dir string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
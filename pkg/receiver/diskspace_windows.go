@@ -0,0 +1,19 @@
+//go:build windows
+
+package receiver
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the number of bytes available to the current user on the volume holding dir
+func freeSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
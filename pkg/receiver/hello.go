@@ -0,0 +1,62 @@
+package receiver
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/protocol"
+)
+
+// hello returns what this server supports, for the hello exchange
+func (s *Server) hello() protocol.Hello {
+	var features []string
+	if s.storage != nil {
+		features = append(features, protocol.FEATURE_TEXT, protocol.FEATURE_FILE)
+		if _, ok := s.storage.(ParallelStorage); ok && s.maxStreams() > 1 {
+			features = append(features, protocol.FEATURE_PARALLEL)
+		}
+		if _, ok := s.storage.(SyncStorage); ok {
+			features = append(features, protocol.FEATURE_SYNC)
+		}
+	}
+	if s.share != nil {
+		features = append(features, protocol.FEATURE_SHARE)
+	}
+	return protocol.NewHello(crypto.CIPHER, features...)
+}
+
+// handleHello answers the sender's HELLO with what both sides will use.
+// It rejects the connection and returns false when they have nothing in common.
+func (s *Server) handleHello(conn net.Conn, log *slog.Logger, line string) (protocol.Agreement, bool) {
+	var hello protocol.Hello
+	if err := protocol.ParseHello(line, &hello); err != nil {
+		log.Warn("connection rejected", "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid hello")
+		return protocol.Agreement{}, false
+	}
+
+	agreement, err := protocol.Negotiate(s.hello(), hello)
+	var incompatible *protocol.IncompatibleError
+	if errors.As(err, &incompatible) {
+		log.Warn("connection rejected", "reason", err.Error(), "version", hello.Version)
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INCOMPATIBLE, incompatible.Reason)
+		return protocol.Agreement{}, false
+	}
+
+	reply, err := protocol.HelloLine(agreement)
+	if err != nil {
+		log.Error("encoding hello failed", "error", err)
+		return protocol.Agreement{}, false
+	}
+	if _, err := conn.Write([]byte(reply + "\n")); err != nil {
+		log.Warn("sending hello failed", "error", err)
+		return protocol.Agreement{}, false
+	}
+	log.Debug("protocol negotiated", "version", agreement.Version, "cipher", agreement.Cipher,
+		"compression", agreement.Compression, "features", agreement.Features)
+	return agreement, true
+}
@@ -0,0 +1,97 @@
+package receiver
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"local-share/pkg/protocol"
+	"local-share/pkg/ratelimit"
+)
+
+const (
+	DEFAULT_MAX_CONNECTIONS = 32
+	DEFAULT_IDLE_TIMEOUT    = 30 * time.Second
+	DEFAULT_MAX_FILE_SIZE   = 4 << 30 // 4GiB
+	DEFAULT_MAX_TEXT_SIZE   = 1 << 20 // 1MiB
+	DEFAULT_MAX_STREAMS     = 8
+	MIN_PART_SIZE           = 1 << 20 // 1MiB, smaller files get fewer streams
+	MAX_HEADER_LINE         = 64 * 1024
+	MAX_BUSY_REJECTS        = 8 // Connections over the limit told at once that the receiver is busy
+)
+
+// Limits protect the receiver against misbehaving senders. Zero values use the defaults.
+type Limits struct {
+	MaxConnections int           // Maximum number of concurrent connections
+	IdleTimeout    time.Duration // Disconnect peers that send nothing for this long
+	MaxFileSize    int64         // Largest file accepted, in bytes
+	MaxTextSize    int64         // Largest text message accepted, in bytes
+	MaxStreams     int           // Most connections one parallel transfer may use, 1 to disable parallel transfers
+	RateLimit      int64         // Bytes per second all connections may transfer together, 0 for no limit
+	ConnRateLimit  int64         // Bytes per second each connection may transfer, 0 for no limit
+}
+
+// withDefaults fills in the limits that were left unset
+func (l Limits) withDefaults() Limits {
+	if l.MaxConnections <= 0 {
+		l.MaxConnections = DEFAULT_MAX_CONNECTIONS
+	}
+	if l.IdleTimeout <= 0 {
+		l.IdleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+	if l.MaxFileSize <= 0 {
+		l.MaxFileSize = DEFAULT_MAX_FILE_SIZE
+	}
+	if l.MaxTextSize <= 0 {
+		l.MaxTextSize = DEFAULT_MAX_TEXT_SIZE
+	}
+	if l.MaxStreams <= 0 {
+		l.MaxStreams = DEFAULT_MAX_STREAMS
+	}
+	return l
+}
+
+// deadlineConn refreshes the read and write deadlines before every operation,
+// so a peer that stops sending is disconnected after the idle timeout
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
+
+// rejectConn sends a refusal and closes the connection gracefully. Closing a socket with
+// unread data resets it, which would discard the reason before the sender reads it.
+func rejectConn(conn net.Conn, code, reason string) {
+	conn.Write([]byte(protocol.RejectLine(code, reason)))
+
+	conn = unwrapConn(conn)
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(io.Discard, io.LimitReader(conn, 1<<20))
+	conn.Close()
+}
+
+// unwrapConn returns the network connection below the deadline and rate limit wrappers
+func unwrapConn(conn net.Conn) net.Conn {
+	for {
+		switch c := conn.(type) {
+		case *deadlineConn:
+			conn = c.Conn
+		case *ratelimit.Conn:
+			conn = c.Conn
+		default:
+			return conn
+		}
+	}
+}
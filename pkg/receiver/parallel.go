@@ -0,0 +1,366 @@
+package receiver
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/progress"
+	"local-share/pkg/protocol"
+)
+
+// RandomAccessUpload is an Upload that can be written at any offset and read back.
+// Parallel transfers need it, since their parts arrive at the same time.
+type RandomAccessUpload interface {
+	Upload
+	io.WriterAt
+	io.ReaderAt
+}
+
+// ParallelStorage is a Storage that can receive files whose parts arrive at the same time
+type ParallelStorage interface {
+	Storage
+	// CreateRandomAccess starts a file like Create whose content can be written in any order
+	CreateRandomAccess(file IncomingFile) (RandomAccessUpload, error)
+}
+
+// parallelTransfer is a file received over several connections
+type parallelTransfer struct {
+	upload   RandomAccessUpload
+	parts    []protocol.Part
+	host     string // Parts must come from the same address as the transfer
+	reporter *progress.Shared
+
+	mu         sync.Mutex
+	claimed    []bool
+	unclaimed  int
+	remaining  int
+	allClaimed chan struct{} // Closed when every part has a connection
+	done       chan struct{} // Closed when every part was received
+	failed     chan struct{} // Closed when a part failed
+	err        error
+}
+
+// claim reserves part index for one connection
+func (t *parallelTransfer) claim(index int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index < 0 || index >= len(t.parts) {
+		return fmt.Errorf("invalid part %d", index)
+	}
+	if t.claimed[index] {
+		return fmt.Errorf("part %d already received", index)
+	}
+	t.claimed[index] = true
+	t.unclaimed--
+	if t.unclaimed == 0 {
+		close(t.allClaimed)
+	}
+	return nil
+}
+
+// complete records that a part was received
+func (t *parallelTransfer) complete() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remaining--
+	if t.remaining == 0 {
+		close(t.done)
+	}
+}
+
+// fail aborts the transfer; only the first error is kept
+func (t *parallelTransfer) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+		close(t.failed)
+	}
+}
+
+// maxStreams returns the most connections one parallel transfer may use. The announcing
+// connection takes a slot of its own, so the parts must leave one for it.
+func (s *Server) maxStreams() int {
+	return min(s.limits.MaxStreams, s.limits.MaxConnections-1)
+}
+
+// streamsFor returns how many connections a file of size bytes may use when the sender asked for requested
+func (s *Server) streamsFor(size int64, requested int) int {
+	streams := min(requested, s.maxStreams(), int(size/MIN_PART_SIZE))
+	return max(streams, 1)
+}
+
+// handleParallel receives a file whose parts arrive on separate RANGE connections.
+// The announcing connection stays open and gets the result once every part is in.
+func (s *Server) handleParallel(conn net.Conn, log *slog.Logger, device, encryptedHeader string) {
+	started := time.Now()
+
+	// The sender falls back to a single connection, so this is checked before the user is asked
+	// for approval; otherwise they would be asked again for the same file
+	storage, ok := s.storage.(ParallelStorage)
+	if !ok {
+		log.Info("parallel transfer rejected", "reason", "storage cannot write at offsets")
+		rejectConn(conn, protocol.REJECT_UNSUPPORTED, "this receiver does not support parallel transfers")
+		return
+	}
+	if s.maxStreams() < 2 {
+		log.Info("parallel transfer rejected", "reason", "disabled")
+		rejectConn(conn, protocol.REJECT_UNSUPPORTED, "this receiver does not support parallel transfers")
+		return
+	}
+
+	header, err := crypto.Decrypt(encryptedHeader, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting transfer header failed", "error", err)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid parallel transfer header")
+		return
+	}
+	fields := strings.Split(header, "\n")
+	if len(fields) != 3 {
+		log.Warn("invalid parallel transfer header")
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid parallel transfer header")
+		return
+	}
+	filename := fields[0]
+	fileSize, err := strconv.ParseInt(fields[1], 10, 64)
+	requested, err2 := strconv.Atoi(fields[2])
+	if err != nil || err2 != nil || fileSize < 0 || requested < 1 {
+		log.Warn("invalid parallel transfer header", "file", filename)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid parallel transfer header")
+		return
+	}
+	log = log.With("file", filename)
+
+	if fileSize > s.limits.MaxFileSize {
+		s.stats.rejected.Add(1)
+		log.Warn("file rejected", "reason", "size limit", "bytes", fileSize, "limit", s.limits.MaxFileSize)
+		rejectConn(conn, protocol.REJECT_LIMIT, fmt.Sprintf("file too large (max %s)", progress.FormatSize(s.limits.MaxFileSize)))
+		return
+	}
+
+	var randomAccess RandomAccessUpload
+	upload, release, ok := s.createUpload(conn, log, device, filename, fileSize, func(file IncomingFile) (Upload, error) {
+		var err error
+		randomAccess, err = storage.CreateRandomAccess(file)
+		return randomAccess, err
+	}, started)
+	if !ok {
+		return
+	}
+	defer release()
+
+	streams := s.streamsFor(fileSize, requested)
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	transfer := &parallelTransfer{
+		upload:     randomAccess,
+		parts:      protocol.SplitParts(fileSize, streams),
+		host:       host,
+		claimed:    make([]bool, streams),
+		unclaimed:  streams,
+		remaining:  streams,
+		allClaimed: make(chan struct{}),
+		done:       make(chan struct{}),
+		failed:     make(chan struct{}),
+	}
+	if s.progress != nil {
+		reporter := s.progress(filename, fileSize)
+		defer reporter.Finish()
+		transfer.reporter = progress.NewShared(reporter)
+	}
+	id := newTransferID()
+	s.transfers.Store(id, transfer)
+	defer s.transfers.Delete(id)
+
+	reply, err := crypto.Encrypt([]byte(id+"\n"+strconv.Itoa(streams)), []byte(s.key))
+	if err != nil {
+		log.Error("encrypting transfer ID failed", "error", err)
+		upload.Abort()
+		return
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n%s\n", protocol.ACCEPT, reply); err != nil {
+		log.Warn("accepting transfer failed", "error", err)
+		upload.Abort()
+		return
+	}
+	log.Info("parallel transfer accepted", "parallel", id, "streams", streams, "bytes", fileSize)
+
+	// Nothing more arrives on this connection; it ending means the sender gave up
+	raw := unwrapConn(conn)
+	raw.SetReadDeadline(time.Time{})
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, raw)
+		close(closed)
+	}()
+
+	// Every part must have started within the idle timeout; after that the
+	// part connections enforce it themselves
+	idle := time.NewTimer(s.limits.IdleTimeout)
+	defer idle.Stop()
+	select {
+	case <-transfer.allClaimed:
+		select {
+		case <-transfer.done:
+		case <-transfer.failed:
+		case <-closed:
+			transfer.fail(errors.New("sender disconnected"))
+		}
+	case <-transfer.failed:
+	case <-closed:
+		transfer.fail(errors.New("sender disconnected"))
+	case <-idle.C:
+		transfer.fail(errors.New("not all parts were sent"))
+	}
+
+	select {
+	case <-transfer.done:
+	default:
+		transfer.fail(errors.New("incomplete file content"))
+		log.Warn("receiving file content failed", "error", transfer.err, "duration", time.Since(started))
+		s.stats.failed.Add(1)
+		upload.Abort()
+		rejectConn(conn, protocol.REJECT_FAILED, transfer.err.Error())
+		return
+	}
+
+	// Verify the reassembled file as a whole
+	hash := sha256.New()
+	if _, err := io.CopyBuffer(hash, io.NewSectionReader(randomAccess, 0, fileSize), make([]byte, BUFFER_SIZE)); err != nil {
+		log.Error("reading received file failed", "error", err)
+		s.stats.failed.Add(1)
+		upload.Abort()
+		rejectConn(conn, protocol.REJECT_FAILED, "could not store file")
+		return
+	}
+
+	path, err := upload.Commit()
+	if errors.Is(err, ErrFileExists) {
+		log.Warn("file rejected", "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_EXISTS, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error("storing file failed", "error", err)
+		s.stats.failed.Add(1)
+		rejectConn(conn, protocol.REJECT_FAILED, "could not store file")
+		return
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	s.writeDone(conn, log, filepath.Base(path), checksum)
+	log.Info("file received", "path", path, "bytes", fileSize, "streams", streams, "duration", time.Since(started), "sha256", checksum)
+
+	s.stats.files.Add(1)
+	s.stats.bytes.Add(fileSize)
+	if s.onFile != nil {
+		s.onFile(ReceivedFile{
+			RemoteAddr: conn.RemoteAddr().String(),
+			Device:     device,
+			Name:       filename,
+			Path:       path,
+			Size:       fileSize,
+			Checksum:   checksum,
+			Duration:   time.Since(started),
+			Received:   time.Now(),
+		})
+	}
+}
+
+// handleRange receives one part of a parallel transfer and confirms it with its checksum
+func (s *Server) handleRange(conn net.Conn, reader *bufio.Reader, log *slog.Logger, encryptedHeader string) {
+	header, err := crypto.Decrypt(encryptedHeader, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting part header failed", "error", err)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid part header")
+		return
+	}
+	id, indexStr, _ := strings.Cut(header, "\n")
+	index, err := strconv.Atoi(indexStr)
+	value, ok := s.transfers.Load(id)
+	if err != nil || !ok {
+		log.Warn("part rejected", "reason", "unknown transfer", "parallel", id)
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, "unknown parallel transfer")
+		return
+	}
+	transfer := value.(*parallelTransfer)
+	log = log.With("parallel", id, "part", index)
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if host != transfer.host {
+		log.Warn("part rejected", "reason", "different sender")
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, "unknown parallel transfer")
+		return
+	}
+	if err := transfer.claim(index); err != nil {
+		log.Warn("part rejected", "reason", err.Error())
+		rejectConn(conn, protocol.REJECT_INVALID, err.Error())
+		return
+	}
+	part := transfer.parts[index]
+
+	// The part is announced with its length, like a whole file
+	lengthStr, err := protocol.ReadLine(reader, MAX_HEADER_LINE)
+	if err != nil {
+		log.Warn("reading content length failed", "error", err)
+		transfer.fail(fmt.Errorf("part %d: %v", index, err))
+		return
+	}
+	contentLength, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || contentLength != crypto.EncryptedSize(part.Length) {
+		log.Warn("invalid content length", "value", lengthStr)
+		transfer.fail(fmt.Errorf("part %d: invalid content length", index))
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid content length")
+		return
+	}
+
+	if _, err := conn.Write([]byte(protocol.ACCEPT + "\n")); err != nil {
+		log.Warn("accepting part failed", "error", err)
+		transfer.fail(fmt.Errorf("part %d: %v", index, err))
+		return
+	}
+
+	content := &io.LimitedReader{R: reader, N: contentLength}
+	decrypted, err := crypto.NewDecryptReader(content, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting part failed", "error", err)
+		transfer.fail(fmt.Errorf("part %d: invalid content", index))
+		rejectConn(conn, protocol.REJECT_FAILED, "invalid file content")
+		return
+	}
+
+	hash := sha256.New()
+	var destination io.Writer = io.MultiWriter(io.NewOffsetWriter(transfer.upload, part.Offset), hash)
+	if transfer.reporter != nil {
+		destination = &progress.Writer{W: destination, Reporter: transfer.reporter.Part()}
+	}
+	written, err := io.CopyBuffer(destination, io.LimitReader(decrypted, part.Length), make([]byte, BUFFER_SIZE))
+	if err == nil && written < part.Length {
+		err = fmt.Errorf("connection closed with %d bytes missing", part.Length-written)
+	}
+	if err != nil {
+		log.Warn("receiving part failed", "error", err, "bytes", written)
+		transfer.fail(fmt.Errorf("part %d: incomplete content", index))
+		rejectConn(conn, protocol.REJECT_FAILED, "incomplete file content")
+		return
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	s.writeDone(conn, log, strconv.Itoa(index), checksum)
+	log.Debug("part received", "bytes", written, "sha256", checksum)
+	transfer.complete()
+}
@@ -0,0 +1,29 @@
+package receiver
+
+import "testing"
+
+func TestStreamsFor(t *testing.T) {
+	tests := []struct {
+		limits    Limits
+		size      int64
+		requested int
+		want      int
+	}{
+		{Limits{}, 8 * MIN_PART_SIZE, 4, 4},
+		{Limits{}, 64 * MIN_PART_SIZE, 16, DEFAULT_MAX_STREAMS},
+		{Limits{}, 3*MIN_PART_SIZE - 1, 8, 2}, // One connection per part of at least MIN_PART_SIZE
+		{Limits{}, MIN_PART_SIZE - 1, 8, 1},
+		{Limits{MaxStreams: 1}, 64 * MIN_PART_SIZE, 8, 1},
+		// The announcing connection needs a slot of its own
+		{Limits{MaxConnections: 4}, 64 * MIN_PART_SIZE, 8, 3},
+		{Limits{MaxConnections: 2}, 64 * MIN_PART_SIZE, 8, 1},
+		{Limits{MaxConnections: 1}, 64 * MIN_PART_SIZE, 8, 1},
+		{Limits{MaxConnections: 100, MaxStreams: 12}, 64 * MIN_PART_SIZE, 16, 12},
+	}
+	for _, test := range tests {
+		s := NewServer(WithLimits(test.limits))
+		if got := s.streamsFor(test.size, test.requested); got != test.want {
+			t.Errorf("streamsFor(%d, %d) with %+v = %d, want %d", test.size, test.requested, test.limits, got, test.want)
+		}
+	}
+}
@@ -1,144 +1,355 @@
 package receiver
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
+	"time"
 
 	"local-share/pkg/crypto"
+	"local-share/pkg/history"
+	"local-share/pkg/output"
+	"local-share/pkg/progress"
 )
 
 const (
-	PORT        = ":8080"
-	BUFFER_SIZE = 1024 * 1024 // 1MB buffer for file transfers
+	PORT                     = ":8080"
+	BUFFER_SIZE              = 1024 * 1024 // 1MB buffer for file transfers
+	DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
 )
 
-// Start starts the receiver server
-func Start() {
-	// Get the encryption key
-	encryptionKey, err := crypto.GetEncryptionKey(true)
-	if err != nil {
-		fmt.Printf("Error getting encryption key: %v\n", err)
-		return
-	}
+// Errors returned by Start, wrapping the underlying cause. Test for them with errors.Is.
+var (
+	ErrNoKey   = errors.New("no usable encryption key")
+	ErrConfig  = errors.New("invalid configuration")
+	ErrStorage = errors.New("cannot use the upload directory")
+	ErrListen  = errors.New("cannot listen for connections")
+)
 
-	// Create uploads directory if it doesn't exist
-	if err := os.MkdirAll("uploads", 0755); err != nil {
-		fmt.Printf("Error creating uploads directory: %v\n", err)
-		return
+// Config holds the receiver settings chosen on the command line
+type Config struct {
+	Listen             string   // Address to listen on, ":8080" if empty
+	UploadDir          string   // Where received files are stored, "uploads" if empty
+	Conflict           string   // What happens to files that already exist (CONFLICT_*), overwrite if empty
+	Layout             string   // Path of received files below UploadDir (see DirStorage.SetLayout), "{name}" if empty
+	KeySource          string   // Where the password comes from (see crypto.GetEncryptionKeyFrom)
+	InsecureNoPassword bool     // Use the well-known insecure key instead of a password
+	Approve            bool     // Ask before accepting each incoming file
+	AutoAccept         []string // Device names or IPs (glob patterns) accepted without asking
+	Allow              []string // IPs or CIDR ranges allowed to connect (empty allows everyone)
+	Deny               []string // IPs or CIDR ranges refused even if allowed
+	RulesFile          string   // Optional file with additional "allow"/"deny" rules
+	Share              string   // Directory to share read-only instead of receiving files (serve mode)
+
+	MaxConnections int           // Maximum number of concurrent connections
+	IdleTimeout    time.Duration // Disconnect peers that send nothing for this long
+	MaxFileSize    int64         // Largest file accepted, in bytes
+	MaxTextSize    int64         // Largest text message accepted, in bytes
+	MaxStreams     int           // Most connections one parallel transfer may use
+	RateLimit      int64         // Bytes per second all connections may transfer together, 0 for no limit
+	ConnRateLimit  int64         // Bytes per second each connection may transfer, 0 for no limit
+	Quota          int64         // Maximum total size of the uploads directory, 0 for no quota
+
+	ShutdownTimeout time.Duration // How long running transfers may take to finish on shutdown
+
+	Logger  *slog.Logger    // Diagnostics, logged as text to stderr if nil
+	History *history.Store  // Where received messages and files are recorded, nil to keep no history
+	Output  *output.Printer // Where events are printed, nil for human readable stdout
+}
+
+// Start runs the receiver from the command line: it prompts for the password,
+// prints what is received and stops gracefully on SIGINT/SIGTERM.
+// With config.Share set it shares that directory read-only instead of receiving.
+// It returns nil after a clean shutdown.
+func Start(config Config) error {
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	if config.Logger == nil {
+		config.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+	out := config.Output
+	if out == nil {
+		out = output.Stdout
 	}
 
-	// Start listening on port
-	listener, err := net.Listen("tcp", PORT)
+	// Get the encryption key
+	encryptionKey, err := crypto.GetEncryptionKeyFrom(config.KeySource, true, config.InsecureNoPassword)
 	if err != nil {
-		fmt.Printf("Error starting server: %v\n", err)
-		return
+		out.Error("Error getting encryption key", err)
+		return fmt.Errorf("%w: %w", ErrNoKey, err)
 	}
-	defer listener.Close()
 
-	fmt.Printf("Server listening on port %s\n", PORT)
-	fmt.Printf("Your IP address: %s\n", getLocalIP())
+	if config.InsecureNoPassword {
+		printInsecureBanner(out)
+	}
 
-	for {
-		conn, err := listener.Accept()
+	// Build the access list from the flags and the rules file
+	allow, deny := config.Allow, config.Deny
+	if config.RulesFile != "" {
+		fileAllow, fileDeny, err := LoadAccessRules(config.RulesFile)
 		if err != nil {
-			fmt.Printf("Error accepting connection: %v\n", err)
-			continue
+			out.Error("Error loading access rules", err)
+			return fmt.Errorf("%w: %w", ErrConfig, err)
 		}
-
-		// Get and display the remote address
-		remoteAddr := conn.RemoteAddr().String()
-		fmt.Printf("New connection from: %s\n", remoteAddr)
-
-		go handleConnection(conn, encryptionKey)
+		allow = append(allow, fileAllow...)
+		deny = append(deny, fileDeny...)
 	}
-}
-
-func handleConnection(conn net.Conn, encryptionKey string) {
-	defer conn.Close()
-
-	reader := bufio.NewReader(conn)
-
-	// Read the first line to determine the type of transfer
-	firstLine, err := reader.ReadString('\n')
+	accessList, err := NewAccessList(allow, deny)
 	if err != nil {
-		fmt.Printf("Error reading first line: %v\n", err)
-		return
+		out.Error("Error parsing access rules", err)
+		return fmt.Errorf("%w: %w", ErrConfig, err)
 	}
-	firstLine = strings.TrimSpace(firstLine)
 
-	if strings.HasPrefix(firstLine, "FILE:") {
-		// Handle encrypted file transfer
-		handleFileTransfer(conn, reader, firstLine[5:], encryptionKey)
-	} else if strings.HasPrefix(firstLine, "TEXT:") {
-		// Handle encrypted text transfer
-		encryptedMsg := firstLine[5:]
-		decryptedMsg, err := crypto.Decrypt(encryptedMsg, []byte(encryptionKey))
-		if err != nil {
-			fmt.Printf("Error decrypting message: %v\n", err)
-			return
+	options := []Option{
+		WithKey(encryptionKey),
+		WithLogger(config.Logger),
+		WithAccessList(accessList),
+		WithLimits(Limits{
+			MaxConnections: config.MaxConnections,
+			IdleTimeout:    config.IdleTimeout,
+			MaxFileSize:    config.MaxFileSize,
+			MaxTextSize:    config.MaxTextSize,
+			MaxStreams:     config.MaxStreams,
+			RateLimit:      config.RateLimit,
+			ConnRateLimit:  config.ConnRateLimit,
+		}),
+		WithTextHandler(func(msg TextMessage) {
+			out.Event("text", output.Fields{
+				"from":   msg.Device,
+				"remote": msg.RemoteAddr,
+				"text":   msg.Text,
+			}, "Received decrypted text from %s: %s\n", msg.Device, msg.Text)
+			record(config, history.Record{
+				Time:   msg.Received,
+				Type:   history.TYPE_TEXT,
+				Device: msg.Device,
+				Remote: msg.RemoteAddr,
+				Text:   msg.Text,
+				Bytes:  int64(len(msg.Text)),
+			})
+		}),
+		WithFileHandler(func(file ReceivedFile) {
+			out.Event("file", output.Fields{
+				"from":        file.Device,
+				"remote":      file.RemoteAddr,
+				"name":        file.Name,
+				"path":        file.Path,
+				"bytes":       file.Size,
+				"sha256":      file.Checksum,
+				"duration_ms": file.Duration.Milliseconds(),
+			}, "Received and decrypted file from %s: %s\n", file.Device, file.Name)
+			record(config, history.Record{
+				Time:       file.Received,
+				Type:       history.TYPE_FILE,
+				Device:     file.Device,
+				Remote:     file.RemoteAddr,
+				Name:       file.Name,
+				Path:       file.Path,
+				Bytes:      file.Size,
+				SHA256:     file.Checksum,
+				DurationMS: file.Duration.Milliseconds(),
+			})
+		}),
+	}
+	// Either share a directory read-only or receive into the upload directory
+	var storage *DirStorage
+	var share *DirShare
+	if config.Share != "" {
+		if share, err = NewDirShare(config.Share); err != nil {
+			out.Error("Error opening shared directory", err)
+			return fmt.Errorf("%w: %w", ErrStorage, err)
 		}
-		fmt.Printf("Received decrypted text: %s\n", decryptedMsg)
+		options = append(options, WithShare(share), WithServeHandler(func(file ServedFile) {
+			out.Event("served", output.Fields{
+				"from":        file.Device,
+				"remote":      file.RemoteAddr,
+				"name":        file.Name,
+				"bytes":       file.Size,
+				"sha256":      file.Checksum,
+				"duration_ms": file.Duration.Milliseconds(),
+			}, "Sent %s to %s (%s)\n", file.Name, file.Device, progress.FormatSize(file.Size))
+		}))
+	} else {
+		if storage, err = openStorage(config, out); err != nil {
+			return err
+		}
+		options = append(options, WithStorage(storage), WithDeleteHandler(func(file DeletedFile) {
+			out.Event("deleted", output.Fields{
+				"from":   file.Device,
+				"remote": file.RemoteAddr,
+				"name":   file.Name,
+			}, "Deleted %s for %s\n", file.Name, file.Device)
+		}))
 	}
-}
 
-func handleFileTransfer(conn net.Conn, reader *bufio.Reader, encryptedFilename string, encryptionKey string) {
-	// Decrypt the filename
-	filename, err := crypto.Decrypt(encryptedFilename, []byte(encryptionKey))
-	if err != nil {
-		fmt.Printf("Error decrypting filename: %v\n", err)
-		return
+	// Keep stdout for JSON events: no progress bars, approval prompts go to stderr
+	prompts := os.Stdout
+	if out.JSON() {
+		prompts = os.Stderr
+	} else {
+		// Progress bars are only drawn when a terminal is watching
+		options = append(options, WithProgress(progress.TerminalFactory(os.Stdout)))
+	}
+	if config.Approve {
+		options = append(options, WithApprover(NewPromptApprover(os.Stdin, prompts, config.AutoAccept)))
 	}
+	srv := NewServer(options...)
 
-	// Read the content length
-	lengthStr, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Printf("Error reading content length: %v\n", err)
-		return
+	// Start listening on port
+	address := PORT
+	if config.Listen != "" {
+		address = config.Listen
 	}
-	contentLength := 0
-	_, err = fmt.Sscanf(strings.TrimSpace(lengthStr), "%d", &contentLength)
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		fmt.Printf("Error parsing content length: %v\n", err)
-		return
+		out.Error("Error starting server", err)
+		return fmt.Errorf("%w: %w", ErrListen, err)
 	}
 
-	// Read the encrypted content
-	encryptedContent := make([]byte, contentLength)
-	_, err = io.ReadFull(reader, encryptedContent)
-	if err != nil {
-		fmt.Printf("Error reading file content: %v\n", err)
-		return
+	localIP := getLocalIP()
+	fingerprint := crypto.Fingerprint(encryptionKey)
+	banner := fmt.Sprintf("Server listening on port %s\nYour IP address: %s\nKey fingerprint: %s\n", address, localIP, fingerprint)
+	fields := output.Fields{
+		"port":            address,
+		"ip":              localIP,
+		"fingerprint":     fingerprint,
+		"approve":         config.Approve,
+		"quota_bytes":     config.Quota,
+		"rate_limit":      config.RateLimit,
+		"conn_rate_limit": config.ConnRateLimit,
+	}
+	if share != nil {
+		banner += fmt.Sprintf("Sharing %s (read-only)\n", share.Dir())
+		fields["dir"], fields["share"] = share.Dir(), true
+	} else {
+		banner += fmt.Sprintf("Saving files to %s\n", filepath.Join(storage.Dir(), filepath.FromSlash(storage.Layout())))
+		fields["dir"], fields["layout"] = storage.Dir(), storage.Layout()
+	}
+	if config.Approve {
+		banner += "Incoming files must be approved before they are received\n"
+	}
+	if !accessList.Empty() {
+		banner += fmt.Sprintf("Access rules active: %d allow, %d deny\n", len(allow), len(deny))
 	}
+	if config.Quota > 0 {
+		banner += fmt.Sprintf("Upload quota: %s\n", progress.FormatSize(config.Quota))
+	}
+	if config.RateLimit > 0 {
+		banner += fmt.Sprintf("Bandwidth limit: %s/s\n", progress.FormatSize(config.RateLimit))
+	}
+	if config.ConnRateLimit > 0 {
+		banner += fmt.Sprintf("Bandwidth limit per connection: %s/s\n", progress.FormatSize(config.ConnRateLimit))
+	}
+	out.Event("listening", fields, "%s", banner)
 
-	// Decrypt the content
-	decryptedContent, err := crypto.Decrypt(string(encryptedContent), []byte(encryptionKey))
-	if err != nil {
-		fmt.Printf("Error decrypting file content: %v\n", err)
-		return
+	// Stop accepting on SIGINT/SIGTERM and let running transfers finish
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(stopped)
+		<-signals
+
+		if active := srv.ActiveConnections(); active > 0 {
+			out.Event("shutdown", output.Fields{"active": active},
+				"Shutting down: waiting up to %s for %d transfer(s) to finish (press Ctrl+C again to abort)\n",
+				config.ShutdownTimeout, active)
+		} else {
+			out.Event("shutdown", output.Fields{"active": 0}, "Shutting down\n")
+		}
+
+		// A second signal skips the draining
+		go func() {
+			<-signals
+			out.Message("Forced shutdown, aborting running transfers")
+			cancel()
+		}()
+
+		shutdownCtx, cancelTimeout := context.WithTimeout(ctx, config.ShutdownTimeout)
+		defer cancelTimeout()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			out.Message("Stopped waiting for transfers, aborted them")
+		}
+	}()
+
+	if err := srv.Serve(ctx, listener); err != ErrServerClosed {
+		out.Error("Error running server", err)
+		return err
 	}
+	<-stopped
 
-	// Create the file in uploads directory
-	filepath := filepath.Join("uploads", filename)
-	file, err := os.Create(filepath)
-	if err != nil {
-		fmt.Printf("Error creating file: %v\n", err)
-		return
+	stats := srv.Stats()
+	fields = output.Fields{
+		"files":        stats.Files,
+		"bytes":        stats.Bytes,
+		"messages":     stats.Texts,
+		"served":       stats.Served,
+		"served_bytes": stats.ServedBytes,
+		"rejected":     stats.Rejected,
+		"failed":       stats.Failed,
 	}
-	defer file.Close()
+	if share != nil {
+		out.Event("stopped", fields, "Share stopped: %d file(s) downloaded (%s), %d rejected, %d failed\n",
+			stats.Served, progress.FormatSize(stats.ServedBytes), stats.Rejected, stats.Failed)
+	} else {
+		out.Event("stopped", fields, "Receiver stopped: %d file(s) received (%s), %d message(s), %d rejected, %d failed\n",
+			stats.Files, progress.FormatSize(stats.Bytes), stats.Texts, stats.Rejected, stats.Failed)
+	}
+	return nil
+}
 
-	// Write the decrypted content
-	_, err = file.Write([]byte(decryptedContent))
+// openStorage creates the upload directory and applies the storage settings of config
+func openStorage(config Config, out *output.Printer) (*DirStorage, error) {
+	// Create uploads directory if it doesn't exist
+	uploadDir := config.UploadDir
+	if uploadDir == "" {
+		uploadDir = DEFAULT_UPLOAD_DIR
+	}
+	storage, err := NewDirStorage(uploadDir, config.Quota)
 	if err != nil {
-		fmt.Printf("Error writing file: %v\n", err)
-		return
+		out.Error("Error creating uploads directory", err)
+		return nil, fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+	if config.Conflict != "" {
+		if err := storage.SetConflictPolicy(config.Conflict); err != nil {
+			out.Error("Error in conflict policy", err)
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+	}
+	if config.Layout != "" {
+		if err := storage.SetLayout(config.Layout); err != nil {
+			out.Error("Error in upload layout", err)
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
 	}
 
-	fmt.Printf("Received and decrypted file: %s\n", filename)
+	// Remove partial files left behind by a receiver that was killed
+	if removed := storage.RemoveStaleTempFiles(); removed > 0 {
+		out.Event("cleanup", output.Fields{"removed": removed},
+			"Removed %d incomplete file(s) from a previous run\n", removed)
+	}
+	return storage, nil
+}
+
+// printInsecureBanner warns that transfers can be read by anyone on the network
+func printInsecureBanner(out *output.Printer) {
+	out.Event("warning", output.Fields{
+		"message": "running with --insecure-no-password, transfers use a well-known key and are NOT confidential",
+	}, "%s",
+		"****************************************************************\n"+
+			"* WARNING: running with --insecure-no-password                 *\n"+
+			"* Transfers use a well-known key and are NOT confidential.     *\n"+
+			"* Anyone on the network can read and send files to this host.  *\n"+
+			"****************************************************************\n")
 }
 
 func getLocalIP() string {
@@ -172,3 +383,13 @@ func getLocalIP() string {
 
 	return "127.0.0.1" // Loopback as fallback
 }
+
+// record adds r to the history, if one is kept. A failure is logged but does not stop the receiver.
+func record(config Config, r history.Record) {
+	if config.History == nil {
+		return
+	}
+	if _, err := config.History.Add(r); err != nil {
+		config.Logger.Error("recording history failed", "error", err)
+	}
+}
@@ -0,0 +1,44 @@
+// Package receivertest runs receivers for tests of code that talks to them
+package receivertest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"local-share/pkg/receiver"
+)
+
+// Receiver is a receiver serving on a random local port
+type Receiver struct {
+	*receiver.Server
+	Addr string // Address it listens on
+	Dir  string // Upload directory
+}
+
+// Start serves with key on a random local port until the test ends. Files are stored in a
+// temporary directory; options come after the key and the storage, so they can replace both.
+func Start(t testing.TB, key string, options ...receiver.Option) *Receiver {
+	t.Helper()
+	dir := t.TempDir()
+	storage, err := receiver.NewDirStorage(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := receiver.NewServer(append([]receiver.Option{receiver.WithKey(key), receiver.WithStorage(storage)}, options...)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return &Receiver{Server: server, Addr: listener.Addr().String(), Dir: dir}
+}
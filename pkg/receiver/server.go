@@ -0,0 +1,881 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/progress"
+	"local-share/pkg/protocol"
+	"local-share/pkg/ratelimit"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown was called
+var ErrServerClosed = errors.New("receiver: server closed")
+
+// KeyProvider returns the (padded) encryption key shared with the senders
+type KeyProvider func() (string, error)
+
+// TextMessage is a text received from a sender
+type TextMessage struct {
+	RemoteAddr string
+	Device     string
+	Text       string
+	Received   time.Time
+}
+
+// ReceivedFile describes a file that was stored
+type ReceivedFile struct {
+	RemoteAddr string
+	Device     string
+	Name       string // Filename announced by the sender
+	Path       string // Where the storage put the file
+	Size       int64
+	Checksum   string // SHA-256 of the content, hex encoded
+	Duration   time.Duration
+	Received   time.Time
+}
+
+// ServedFile describes a file a sender downloaded from the share
+type ServedFile struct {
+	RemoteAddr string
+	Device     string
+	Name       string // Path within the share
+	Size       int64
+	Checksum   string // SHA-256 of the content, hex encoded
+	Duration   time.Duration
+}
+
+// ServeHandler is called for every file downloaded from the share
+type ServeHandler func(file ServedFile)
+
+// TextHandler is called for every text message received
+type TextHandler func(msg TextMessage)
+
+// FileHandler is called for every file stored
+type FileHandler func(file ReceivedFile)
+
+// Server receives encrypted text and files from senders.
+// Create it with NewServer and run it with Serve or ListenAndServe.
+type Server struct {
+	address     string
+	keyProvider KeyProvider
+	storage     Storage
+	logger      *slog.Logger
+	onText      TextHandler
+	onFile      FileHandler
+	onServe     ServeHandler
+	onDelete    DeleteHandler
+	share       Share
+	approver    Approver
+	access      *AccessList
+	limits      Limits
+	progress    progress.Factory
+
+	keyOnce sync.Once
+	key     string
+	keyErr  error
+
+	slots     chan struct{}      // Each running connection holds a slot until it finishes
+	busy      chan struct{}      // Each connection being told the receiver is busy holds a slot
+	rate      *ratelimit.Limiter // Shared by all connections, nil without a limit
+	transfers sync.Map           // Parallel transfers waiting for their parts, by ID
+	conns     connTracker
+	stats     stats
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    atomic.Bool
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAddress sets the address ListenAndServe listens on (default ":8080")
+func WithAddress(address string) Option {
+	return func(s *Server) {
+		s.address = address
+	}
+}
+
+// WithKeyProvider sets where the encryption key comes from. It is called once, on the first Serve.
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(s *Server) {
+		s.keyProvider = provider
+	}
+}
+
+// WithKey uses a fixed, already padded encryption key
+func WithKey(key string) Option {
+	return WithKeyProvider(func() (string, error) {
+		return key, nil
+	})
+}
+
+// WithStorage sets where received files are written (default: DirStorage in "uploads")
+func WithStorage(storage Storage) Option {
+	return func(s *Server) {
+		s.storage = storage
+	}
+}
+
+// WithLogger sets the structured logger for diagnostics (default: discard).
+// Connection records carry the "remote" address and a "transfer" ID.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithTextHandler sets the function called for every text message
+func WithTextHandler(handler TextHandler) Option {
+	return func(s *Server) {
+		s.onText = handler
+	}
+}
+
+// WithFileHandler sets the function called for every stored file
+func WithFileHandler(handler FileHandler) Option {
+	return func(s *Server) {
+		s.onFile = handler
+	}
+}
+
+// WithShare lets senders list and download the files of share. A server with a share
+// and no storage only shares: uploads and text messages are refused.
+func WithShare(share Share) Option {
+	return func(s *Server) {
+		s.share = share
+	}
+}
+
+// WithServeHandler sets the function called for every file downloaded from the share
+func WithServeHandler(handler ServeHandler) Option {
+	return func(s *Server) {
+		s.onServe = handler
+	}
+}
+
+// WithDeleteHandler sets the function called for every file a sender removes while synchronizing
+func WithDeleteHandler(handler DeleteHandler) Option {
+	return func(s *Server) {
+		s.onDelete = handler
+	}
+}
+
+// WithApprover asks approver before each file is received (default: accept everything)
+func WithApprover(approver Approver) Option {
+	return func(s *Server) {
+		s.approver = approver
+	}
+}
+
+// WithAccessList refuses connections from addresses the list does not allow
+func WithAccessList(access *AccessList) Option {
+	return func(s *Server) {
+		s.access = access
+	}
+}
+
+// WithLimits sets connection, timeout, size and bandwidth limits
+func WithLimits(limits Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithProgress reports the progress of incoming files to reporters created by factory
+func WithProgress(factory progress.Factory) Option {
+	return func(s *Server) {
+		s.progress = factory
+	}
+}
+
+// NewServer creates a server configured by options
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		address:   PORT,
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		listeners: make(map[net.Listener]struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.limits = s.limits.withDefaults()
+	s.slots = make(chan struct{}, s.limits.MaxConnections)
+	s.busy = make(chan struct{}, MAX_BUSY_REJECTS)
+	s.rate = ratelimit.NewLimiter(s.limits.RateLimit)
+	return s
+}
+
+// ListenAndServe listens on the configured address and serves connections
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until Shutdown is called or ctx is cancelled.
+// Cancelling ctx also aborts the transfers in progress; use Shutdown to let them finish.
+// Serve always closes listener and returns a non-nil error.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	defer listener.Close()
+
+	if s.closed.Load() {
+		return ErrServerClosed
+	}
+	if err := s.prepare(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		s.mu.Unlock()
+	}()
+
+	// Stop accepting and abort running transfers when the context ends
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+		s.conns.closeAll()
+	})
+	defer stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.closed.Load() {
+				return ErrServerClosed
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.logger.Error("accept failed", "error", err)
+			continue
+		}
+
+		// Get and display the remote address
+		remoteAddr := conn.RemoteAddr().String()
+
+		// Enforce the access rules before anything is read from the connection
+		if s.access != nil && !s.access.Allowed(remoteAddr) {
+			s.logger.Warn("connection refused", "remote", remoteAddr, "reason", "not allowed by access rules")
+			conn.Close()
+			continue
+		}
+
+		select {
+		case s.slots <- struct{}{}:
+			log := s.logger.With("remote", remoteAddr, "transfer", newTransferID())
+			log.Info("connection opened")
+			s.conns.add(conn)
+			go func() {
+				defer func() { <-s.slots }()
+				defer s.conns.remove(conn)
+				s.handleConnection(conn, log)
+			}()
+		default:
+			s.logger.Warn("connection refused", "remote", remoteAddr, "reason", "too many connections")
+			// Telling the sender why holds the socket for a moment, so only a few are told
+			// at once; in a flood of connections the rest are closed right away
+			select {
+			case s.busy <- struct{}{}:
+				go func() {
+					defer func() { <-s.busy }()
+					conn.SetWriteDeadline(time.Now().Add(time.Second))
+					rejectConn(conn, protocol.REJECT_LIMIT, fmt.Sprintf("receiver busy (%d connections in progress)", s.limits.MaxConnections))
+				}()
+			default:
+				conn.Close()
+			}
+		}
+	}
+}
+
+// Shutdown stops accepting connections and waits for running transfers to finish.
+// If ctx ends first the remaining transfers are aborted and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closed.Store(true)
+
+	s.mu.Lock()
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	s.mu.Unlock()
+
+	var err error
+	select {
+	case <-s.conns.idle():
+	case <-ctx.Done():
+		s.conns.closeAll()
+		err = ctx.Err()
+	}
+
+	// Remove partial files of transfers that did not complete
+	if cleaner, ok := s.storage.(interface{ RemoveTempFiles() int }); ok {
+		if removed := cleaner.RemoveTempFiles(); removed > 0 {
+			s.logger.Info("removed incomplete files", "count", removed)
+		}
+	}
+	return err
+}
+
+// ActiveConnections returns the number of connections being handled
+func (s *Server) ActiveConnections() int {
+	return s.conns.count()
+}
+
+// Stats returns counters of what the server handled so far
+func (s *Server) Stats() Stats {
+	return s.stats.snapshot()
+}
+
+// prepare loads the key and sets up the default storage before the first connection
+func (s *Server) prepare() error {
+	s.keyOnce.Do(func() {
+		if s.keyProvider == nil {
+			s.keyErr = errors.New("receiver: no encryption key configured")
+			return
+		}
+		s.key, s.keyErr = s.keyProvider()
+	})
+	if s.keyErr != nil {
+		return s.keyErr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.storage == nil && s.share == nil {
+		storage, err := NewDirStorage(DEFAULT_UPLOAD_DIR, 0)
+		if err != nil {
+			return err
+		}
+		s.storage = storage
+	}
+	return nil
+}
+
+func (s *Server) handleConnection(rawConn net.Conn, log *slog.Logger) {
+	defer rawConn.Close()
+
+	// The rate limits apply outside the deadlines, so waiting for them does not count as idle
+	conn := ratelimit.NewConn(&deadlineConn{Conn: rawConn, timeout: s.limits.IdleTimeout},
+		ratelimit.NewLimiter(s.limits.ConnRateLimit), s.rate)
+	reader := bufio.NewReader(conn)
+
+	// Text messages travel on the first line, so allow for their encrypted size
+	maxLine := int(crypto.EncryptedSize(s.limits.MaxTextSize)) + len(protocol.TEXT_PREFIX) + 2
+	if maxLine < MAX_HEADER_LINE {
+		maxLine = MAX_HEADER_LINE
+	}
+
+	// Read the first line to determine the type of transfer
+	firstLine, err := protocol.ReadLine(reader, maxLine)
+	if err != nil {
+		log.Warn("reading first line failed", "error", err)
+		return
+	}
+
+	// Senders of protocol version 2 and newer start with a hello exchange, version 1 senders with FROM.
+	// Version 0 senders start with the transfer itself and are turned away below.
+	if strings.HasPrefix(firstLine, protocol.HELLO_PREFIX) {
+		agreement, ok := s.handleHello(conn, log, firstLine)
+		if !ok {
+			return
+		}
+		log = log.With("protocol", agreement.Version)
+		firstLine, err = protocol.ReadLine(reader, maxLine)
+		if err != nil {
+			log.Warn("reading first line failed", "error", err)
+			return
+		}
+	} else if strings.HasPrefix(firstLine, protocol.FROM_PREFIX) {
+		log = log.With("protocol", protocol.FROM_VERSION)
+		log.Debug("sender predates the hello exchange")
+	}
+
+	// Senders identify their device before the transfer itself. The device name is encrypted,
+	// so it also proves the sender uses the same password; nothing is accepted without it.
+	if !strings.HasPrefix(firstLine, protocol.FROM_PREFIX) {
+		log.Warn("connection rejected", "reason", "sender did not identify itself")
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_AUTH, "sender did not identify itself (outdated local-share?)")
+		return
+	}
+	device, err := s.decryptDevice(firstLine[len(protocol.FROM_PREFIX):])
+	if err != nil {
+		log.Warn("connection rejected", "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_AUTH, err.Error())
+		return
+	}
+
+	log = log.With("device", device)
+	firstLine, err = protocol.ReadLine(reader, maxLine)
+	if err != nil {
+		log.Warn("reading transfer header failed", "error", err)
+		if errors.Is(err, protocol.ErrLineTooLong) {
+			rejectConn(conn, protocol.REJECT_LIMIT, fmt.Sprintf("message too large (max %s)", progress.FormatSize(s.limits.MaxTextSize)))
+		}
+		return
+	}
+
+	readOnly := s.storage == nil
+	syncStorage, canSync := s.storage.(SyncStorage)
+	isSync := strings.HasPrefix(firstLine, protocol.MANIFEST_PREFIX) || strings.HasPrefix(firstLine, protocol.SYNC_PREFIX) ||
+		strings.HasPrefix(firstLine, protocol.DELETE_PREFIX)
+	isParallel := strings.HasPrefix(firstLine, protocol.PARALLEL_PREFIX) || strings.HasPrefix(firstLine, protocol.RANGE_PREFIX)
+	if strings.HasPrefix(firstLine, protocol.LIST_PREFIX) || strings.HasPrefix(firstLine, protocol.GET_PREFIX) {
+		if s.share == nil {
+			log.Warn("request rejected", "reason", "nothing shared")
+			s.stats.rejected.Add(1)
+			rejectConn(conn, protocol.REJECT_INVALID, "this receiver does not share files")
+			return
+		}
+		if strings.HasPrefix(firstLine, protocol.LIST_PREFIX) {
+			s.handleList(conn, log, firstLine[len(protocol.LIST_PREFIX):])
+		} else {
+			s.handleGet(conn, log, device, firstLine[len(protocol.GET_PREFIX):])
+		}
+	} else if readOnly && (isSync || isParallel || strings.HasPrefix(firstLine, protocol.FILE_PREFIX) || strings.HasPrefix(firstLine, protocol.TEXT_PREFIX)) {
+		log.Warn("transfer rejected", "reason", "read-only share")
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_DECLINED, "this receiver only shares files")
+	} else if isSync && !canSync {
+		log.Warn("request rejected", "reason", "storage does not support sync")
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, "this receiver does not support folder synchronization")
+	} else if strings.HasPrefix(firstLine, protocol.MANIFEST_PREFIX) {
+		s.handleManifest(conn, log, syncStorage, firstLine[len(protocol.MANIFEST_PREFIX):])
+	} else if strings.HasPrefix(firstLine, protocol.SYNC_PREFIX) {
+		// Same as a file transfer, but stored at the announced path
+		s.handleFileTransfer(conn, reader, log, device, firstLine[len(protocol.SYNC_PREFIX):], true)
+	} else if strings.HasPrefix(firstLine, protocol.DELETE_PREFIX) {
+		s.handleDelete(conn, log, syncStorage, device, firstLine[len(protocol.DELETE_PREFIX):])
+	} else if strings.HasPrefix(firstLine, protocol.PARALLEL_PREFIX) {
+		s.handleParallel(conn, log, device, firstLine[len(protocol.PARALLEL_PREFIX):])
+	} else if strings.HasPrefix(firstLine, protocol.RANGE_PREFIX) {
+		s.handleRange(conn, reader, log, firstLine[len(protocol.RANGE_PREFIX):])
+	} else if strings.HasPrefix(firstLine, protocol.FILE_PREFIX) {
+		// Handle encrypted file transfer
+		s.handleFileTransfer(conn, reader, log, device, firstLine[len(protocol.FILE_PREFIX):], false)
+	} else if strings.HasPrefix(firstLine, protocol.TEXT_PREFIX) {
+		// Handle encrypted text transfer
+		encryptedMsg := firstLine[len(protocol.TEXT_PREFIX):]
+		decryptedMsg, err := crypto.Decrypt(encryptedMsg, []byte(s.key))
+		if err != nil {
+			log.Warn("decrypting message failed", "error", err)
+			rejectConn(conn, protocol.REJECT_INVALID, "invalid message")
+			return
+		}
+		s.stats.texts.Add(1)
+		checksum := sha256.Sum256([]byte(decryptedMsg))
+		s.writeDone(conn, log, "", hex.EncodeToString(checksum[:]))
+		log.Info("text received", "bytes", len(decryptedMsg))
+		if s.onText != nil {
+			s.onText(TextMessage{
+				RemoteAddr: conn.RemoteAddr().String(),
+				Device:     device,
+				Text:       decryptedMsg,
+				Received:   time.Now(),
+			})
+		}
+	} else {
+		log.Warn("unknown transfer type")
+		rejectConn(conn, protocol.REJECT_INVALID, "unknown transfer type")
+	}
+}
+
+// writeDone tells the sender the transfer completed, with the stored name and content checksum
+func (s *Server) writeDone(conn net.Conn, log *slog.Logger, storedName, checksum string) {
+	result, err := crypto.Encrypt([]byte(storedName+"\n"+checksum), []byte(s.key))
+	if err != nil {
+		log.Error("encrypting result failed", "error", err)
+		return
+	}
+	if _, err := conn.Write([]byte(protocol.DONE_PREFIX + result + "\n")); err != nil {
+		log.Warn("sending result failed", "error", err)
+	}
+}
+
+// decryptDevice decrypts the sender's device name and checks that the same password was used
+func (s *Server) decryptDevice(encryptedDevice string) (string, error) {
+	device, err := crypto.Decrypt(encryptedDevice, []byte(s.key))
+	if err != nil || !strings.HasPrefix(device, protocol.DEVICE_MAGIC) {
+		return "", fmt.Errorf("wrong password")
+	}
+	return device[len(protocol.DEVICE_MAGIC):], nil
+}
+
+// handleFileTransfer receives a file. With atPath set the filename is a sync path
+// and the file replaces the one stored there.
+func (s *Server) handleFileTransfer(conn net.Conn, reader *bufio.Reader, log *slog.Logger, device string, encryptedFilename string, atPath bool) {
+	started := time.Now()
+
+	// Decrypt the filename
+	filename, err := crypto.Decrypt(encryptedFilename, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting filename failed", "error", err)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid filename")
+		return
+	}
+	log = log.With("file", filename)
+
+	// Read the content length
+	lengthStr, err := protocol.ReadLine(reader, MAX_HEADER_LINE)
+	if err != nil {
+		log.Warn("reading content length failed", "error", err)
+		return
+	}
+	contentLength, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || contentLength < 0 {
+		log.Warn("invalid content length", "value", lengthStr)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid content length")
+		return
+	}
+
+	// Refuse oversized files before anything is allocated or written
+	if contentLength > crypto.EncryptedSize(s.limits.MaxFileSize) {
+		s.stats.rejected.Add(1)
+		log.Warn("file rejected", "reason", "size limit", "bytes", crypto.PlaintextSize(contentLength), "limit", s.limits.MaxFileSize)
+		rejectConn(conn, protocol.REJECT_LIMIT, fmt.Sprintf("file too large (max %s)", progress.FormatSize(s.limits.MaxFileSize)))
+		return
+	}
+
+	fileSize := crypto.PlaintextSize(contentLength)
+	create := s.storage.Create
+	if atPath {
+		create = func(IncomingFile) (Upload, error) { return s.storage.(SyncStorage).CreateAt(filename) }
+	}
+	upload, release, ok := s.createUpload(conn, log, device, filename, fileSize, create, started)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if _, err := conn.Write([]byte(protocol.ACCEPT + "\n")); err != nil {
+		log.Warn("accepting transfer failed", "error", err)
+		upload.Abort()
+		return
+	}
+
+	// Decrypt the content while it is read
+	content := &io.LimitedReader{R: reader, N: contentLength}
+	decrypted, err := crypto.NewDecryptReader(content, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting file content failed", "error", err)
+		s.stats.failed.Add(1)
+		upload.Abort()
+		rejectConn(conn, protocol.REJECT_FAILED, "invalid file content")
+		return
+	}
+
+	// Hash the content while it is written so the sender can verify it
+	hash := sha256.New()
+	var destination io.Writer = io.MultiWriter(upload, hash)
+	if s.progress != nil {
+		reporter := s.progress(filename, fileSize)
+		defer reporter.Finish()
+		destination = &progress.Writer{W: destination, Reporter: reporter}
+	}
+	buffer := make([]byte, BUFFER_SIZE)
+	written, err := io.CopyBuffer(destination, decrypted, buffer)
+	if err == nil && content.N > 0 {
+		err = fmt.Errorf("connection closed with %d bytes missing", content.N)
+	}
+	if err != nil {
+		log.Warn("receiving file content failed", "error", err, "bytes", written, "duration", time.Since(started))
+		s.stats.failed.Add(1)
+		upload.Abort()
+		rejectConn(conn, protocol.REJECT_FAILED, "incomplete file content")
+		return
+	}
+
+	// Move the complete file into place
+	path, err := upload.Commit()
+	if errors.Is(err, ErrFileExists) {
+		// Another transfer stored the same name first
+		log.Warn("file rejected", "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_EXISTS, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error("storing file failed", "error", err)
+		s.stats.failed.Add(1)
+		rejectConn(conn, protocol.REJECT_FAILED, "could not store file")
+		return
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	s.writeDone(conn, log, filepath.Base(path), checksum)
+	log.Info("file received", "path", path, "bytes", written, "duration", time.Since(started), "sha256", checksum)
+
+	s.stats.files.Add(1)
+	s.stats.bytes.Add(written)
+	if s.onFile != nil {
+		s.onFile(ReceivedFile{
+			RemoteAddr: conn.RemoteAddr().String(),
+			Device:     device,
+			Name:       filename,
+			Path:       path,
+			Size:       written,
+			Checksum:   checksum,
+			Duration:   time.Since(started),
+			Received:   time.Now(),
+		})
+	}
+}
+
+// createUpload reserves space for a file, asks for approval and creates the upload with create.
+// It rejects the transfer and returns false if any of this fails; otherwise the caller
+// must call release once the transfer ended.
+func (s *Server) createUpload(conn net.Conn, log *slog.Logger, device, filename string, fileSize int64,
+	create func(IncomingFile) (Upload, error), started time.Time) (Upload, func(), bool) {
+	// Make sure the file fits on disk and within the quota
+	release, err := s.storage.Reserve(fileSize)
+	if err != nil {
+		log.Warn("file rejected", "reason", err.Error(), "bytes", fileSize)
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_STORAGE, err.Error())
+		return nil, nil, false
+	}
+
+	// Ask for approval before any bytes are read or written
+	req := TransferRequest{
+		RemoteAddr: conn.RemoteAddr().String(),
+		Device:     device,
+		Files:      []string{filename},
+		TotalSize:  fileSize,
+	}
+	if s.approver != nil && !s.approver.Approve(req) {
+		log.Info("file rejected", "reason", "declined", "bytes", fileSize)
+		s.stats.rejected.Add(1)
+		release()
+		rejectConn(conn, protocol.REJECT_DECLINED, "declined by receiver")
+		return nil, nil, false
+	}
+
+	upload, err := create(IncomingFile{
+		Name:       filename,
+		Device:     device,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Time:       started,
+	})
+	if err != nil {
+		log.Warn("file rejected", "reason", err.Error())
+		s.stats.rejected.Add(1)
+		code := protocol.REJECT_INVALID
+		if errors.Is(err, ErrFileExists) {
+			code = protocol.REJECT_EXISTS
+		}
+		release()
+		rejectConn(conn, code, err.Error())
+		return nil, nil, false
+	}
+	return upload, release, true
+}
+
+// handleList sends the entries of a directory of the share
+func (s *Server) handleList(conn net.Conn, log *slog.Logger, encryptedDir string) {
+	dir, err := crypto.Decrypt(encryptedDir, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting directory failed", "error", err)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid directory")
+		return
+	}
+	entries, err := s.share.List(dir)
+	if err != nil {
+		log.Warn("listing rejected", "dir", dir, "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, err.Error())
+		return
+	}
+	listing, err := json.Marshal(entries)
+	if err != nil {
+		log.Error("encoding listing failed", "error", err)
+		rejectConn(conn, protocol.REJECT_FAILED, "could not list directory")
+		return
+	}
+
+	if _, _, err := s.sendPayload(conn, log, cleanSharePath(dir), bytes.NewReader(listing), int64(len(listing))); err != nil {
+		log.Warn("sending listing failed", "dir", dir, "error", err)
+		return
+	}
+	log.Info("listing sent", "dir", dir, "entries", len(entries))
+}
+
+// handleGet sends a file of the share
+func (s *Server) handleGet(conn net.Conn, log *slog.Logger, device, encryptedName string) {
+	started := time.Now()
+	name, err := crypto.Decrypt(encryptedName, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting filename failed", "error", err)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid filename")
+		return
+	}
+	log = log.With("file", name)
+
+	file, size, err := s.share.Open(name)
+	if err != nil {
+		log.Warn("download rejected", "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, err.Error())
+		return
+	}
+	defer file.Close()
+
+	written, checksum, err := s.sendPayload(conn, log, cleanSharePath(name), file, size)
+	if err != nil {
+		log.Warn("sending file failed", "error", err, "bytes", written, "duration", time.Since(started))
+		s.stats.failed.Add(1)
+		return
+	}
+	log.Info("file sent", "bytes", written, "duration", time.Since(started), "sha256", checksum)
+
+	s.stats.served.Add(1)
+	s.stats.servedBytes.Add(written)
+	if s.onServe != nil {
+		s.onServe(ServedFile{
+			RemoteAddr: conn.RemoteAddr().String(),
+			Device:     device,
+			Name:       cleanSharePath(name),
+			Size:       written,
+			Checksum:   checksum,
+			Duration:   time.Since(started),
+		})
+	}
+}
+
+// handleManifest sends the files below a sync target with their checksums
+func (s *Server) handleManifest(conn net.Conn, log *slog.Logger, storage SyncStorage, encryptedTarget string) {
+	target, err := crypto.Decrypt(encryptedTarget, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting sync target failed", "error", err)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid sync target")
+		return
+	}
+	entries, err := storage.Manifest(target)
+	if err != nil {
+		log.Warn("manifest rejected", "target", target, "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, err.Error())
+		return
+	}
+	manifest, err := json.Marshal(entries)
+	if err != nil {
+		log.Error("encoding manifest failed", "error", err)
+		rejectConn(conn, protocol.REJECT_FAILED, "could not list sync target")
+		return
+	}
+
+	if _, _, err := s.sendPayload(conn, log, cleanSharePath(target), bytes.NewReader(manifest), int64(len(manifest))); err != nil {
+		log.Warn("sending manifest failed", "target", target, "error", err)
+		return
+	}
+	log.Info("manifest sent", "target", target, "files", len(entries))
+}
+
+// handleDelete removes a file of a sync target
+func (s *Server) handleDelete(conn net.Conn, log *slog.Logger, storage SyncStorage, device, encryptedName string) {
+	name, err := crypto.Decrypt(encryptedName, []byte(s.key))
+	if err != nil {
+		log.Warn("decrypting filename failed", "error", err)
+		rejectConn(conn, protocol.REJECT_INVALID, "invalid filename")
+		return
+	}
+	log = log.With("file", name)
+
+	req := TransferRequest{
+		RemoteAddr: conn.RemoteAddr().String(),
+		Device:     device,
+		Files:      []string{name + " (delete)"},
+	}
+	if s.approver != nil && !s.approver.Approve(req) {
+		log.Info("deletion rejected", "reason", "declined")
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_DECLINED, "declined by receiver")
+		return
+	}
+
+	if err := storage.Remove(name); err != nil {
+		log.Warn("deletion rejected", "reason", err.Error())
+		s.stats.rejected.Add(1)
+		rejectConn(conn, protocol.REJECT_INVALID, err.Error())
+		return
+	}
+	s.writeDone(conn, log, cleanSharePath(name), "")
+	log.Info("file deleted")
+
+	if s.onDelete != nil {
+		s.onDelete(DeletedFile{
+			RemoteAddr: conn.RemoteAddr().String(),
+			Device:     device,
+			Name:       cleanSharePath(name),
+		})
+	}
+}
+
+// sendPayload accepts a LIST, GET or MANIFEST request and sends size bytes of content encrypted,
+// followed by DONE with name and the content checksum
+func (s *Server) sendPayload(conn net.Conn, log *slog.Logger, name string, content io.Reader, size int64) (int64, string, error) {
+	writer := bufio.NewWriterSize(conn, BUFFER_SIZE)
+	if _, err := fmt.Fprintf(writer, "%s\n%d\n", protocol.ACCEPT, crypto.EncryptedSize(size)); err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	encrypter, err := crypto.NewEncryptWriter(writer, []byte(s.key))
+	if err != nil {
+		return 0, "", err
+	}
+	written, err := io.CopyBuffer(io.MultiWriter(encrypter, hash), io.LimitReader(content, size), make([]byte, BUFFER_SIZE))
+	if err != nil {
+		return written, "", err
+	}
+	if written != size {
+		// The sender already expects size bytes, so the transfer cannot be completed
+		return written, "", fmt.Errorf("file changed size while it was sent")
+	}
+	if err := encrypter.Close(); err != nil {
+		return written, "", err
+	}
+	if err := writer.Flush(); err != nil {
+		return written, "", err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	s.writeDone(conn, log, name, checksum)
+	return written, checksum, nil
+}
+
+// newTransferID returns a short random ID that ties the log records of one connection together
+func newTransferID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
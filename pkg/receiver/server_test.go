@@ -0,0 +1,199 @@
+package receiver_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/protocol"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+)
+
+var testKey = crypto.PadKey("Str0ng-Passw0rd!")
+
+// exchange sends lines to addr and returns the receiver's first reply
+func exchange(t *testing.T, addr string, lines ...string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading reply: %v", err)
+	}
+	return strings.TrimSpace(reply)
+}
+
+// encrypted returns text encrypted with key
+func encrypted(t *testing.T, text, key string) string {
+	t.Helper()
+	data, err := crypto.Encrypt([]byte(text), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// fromLine identifies the test sender with key
+func fromLine(t *testing.T, key string) string {
+	return protocol.FROM_PREFIX + encrypted(t, protocol.DEVICE_MAGIC+"test-device", key)
+}
+
+func TestSenderMustIdentify(t *testing.T) {
+	var texts []receiver.TextMessage
+	server := receivertest.Start(t, testKey, receiver.WithTextHandler(func(msg receiver.TextMessage) { texts = append(texts, msg) }))
+	otherKey := crypto.PadKey("Other-Passw0rd!x")
+
+	tests := []struct {
+		name  string
+		lines []string
+	}{
+		{"text without FROM", []string{protocol.TEXT_PREFIX + encrypted(t, "hello", testKey)}},
+		{"file without FROM", []string{protocol.FILE_PREFIX + encrypted(t, "name.txt", testKey), "4"}},
+		{"text with another password", []string{protocol.TEXT_PREFIX + encrypted(t, "hello", otherKey)}},
+		{"FROM with another password", []string{fromLine(t, otherKey), protocol.TEXT_PREFIX + encrypted(t, "hello", otherKey)}},
+		{"FROM without the device magic", []string{protocol.FROM_PREFIX + encrypted(t, "test-device", testKey)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply := exchange(t, server.Addr, test.lines...)
+			if !strings.HasPrefix(reply, protocol.REJECT_PREFIX+protocol.REJECT_AUTH+" ") {
+				t.Errorf("reply = %q, want REJECT:%s", reply, protocol.REJECT_AUTH)
+			}
+		})
+	}
+	if stats := server.Stats(); stats.Texts != 0 || stats.Files != 0 || len(texts) != 0 {
+		t.Errorf("unidentified senders were served: %+v, %d texts handled", stats, len(texts))
+	}
+}
+
+func TestUndecryptableRequestIsRejected(t *testing.T) {
+	addr := receivertest.Start(t, testKey).Addr
+	garbage := "bm90IGVuY3J5cHRlZA"
+
+	for _, prefix := range []string{protocol.TEXT_PREFIX, protocol.FILE_PREFIX, protocol.PARALLEL_PREFIX, protocol.RANGE_PREFIX, protocol.MANIFEST_PREFIX} {
+		t.Run(strings.TrimSuffix(prefix, ":"), func(t *testing.T) {
+			reply := exchange(t, addr, fromLine(t, testKey), prefix+garbage)
+			if !strings.HasPrefix(reply, protocol.REJECT_PREFIX+protocol.REJECT_INVALID+" ") {
+				t.Errorf("reply = %q, want REJECT:%s", reply, protocol.REJECT_INVALID)
+			}
+		})
+	}
+}
+
+func TestProtocolVersions(t *testing.T) {
+	addr := receivertest.Start(t, testKey, receiver.WithLimits(receiver.Limits{MaxStreams: 1})).Addr
+	helloLine := func(hello protocol.Hello) string {
+		line, err := protocol.HelloLine(hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+	text := protocol.TEXT_PREFIX + encrypted(t, "hello", testKey)
+
+	// Version 2 senders agree on the protocol first
+	var agreement protocol.Agreement
+	reply := exchange(t, addr, helloLine(protocol.NewHello(crypto.CIPHER)), fromLine(t, testKey), text)
+	if err := protocol.ParseHello(reply, &agreement); err != nil {
+		t.Fatalf("reply to HELLO = %q: %v", reply, err)
+	}
+	if agreement.Version != protocol.VERSION || agreement.Cipher != crypto.CIPHER || !agreement.Supports(protocol.FEATURE_TEXT) ||
+		agreement.Supports(protocol.FEATURE_PARALLEL) || agreement.Supports(protocol.FEATURE_SHARE) {
+		t.Errorf("agreement = %+v, want version %d offering text but neither parallel transfers nor shares", agreement, protocol.VERSION)
+	}
+
+	// Version 1 senders start with FROM
+	if reply := exchange(t, addr, fromLine(t, testKey), text); !strings.HasPrefix(reply, protocol.DONE_PREFIX) {
+		t.Errorf("reply to a version 1 sender = %q, want DONE", reply)
+	}
+
+	old := protocol.Hello{Version: 1, MinVersion: 1, Ciphers: []string{crypto.CIPHER}, Compression: []string{protocol.COMPRESSION_NONE}}
+	otherCipher := protocol.NewHello("rot13")
+	for _, line := range []string{helloLine(old), helloLine(otherCipher)} {
+		if reply := exchange(t, addr, line); !strings.HasPrefix(reply, protocol.REJECT_PREFIX+protocol.REJECT_INCOMPATIBLE+" ") {
+			t.Errorf("reply to %s = %q, want REJECT:%s", line, reply, protocol.REJECT_INCOMPATIBLE)
+		}
+	}
+	if reply := exchange(t, addr, protocol.HELLO_PREFIX+"{"); !strings.HasPrefix(reply, protocol.REJECT_PREFIX+protocol.REJECT_INVALID+" ") {
+		t.Errorf("reply to an invalid HELLO = %q, want REJECT:%s", reply, protocol.REJECT_INVALID)
+	}
+}
+
+// sequentialStorage hides that its storage can write at offsets
+type sequentialStorage struct {
+	receiver.Storage
+}
+
+func TestParallelNeedsRandomAccess(t *testing.T) {
+	storage, err := receiver.NewDirStorage(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asked atomic.Int32
+	addr := receivertest.Start(t, testKey, receiver.WithStorage(sequentialStorage{storage}),
+		receiver.WithApprover(receiver.ApproverFunc(func(receiver.TransferRequest) bool { asked.Add(1); return true }))).Addr
+
+	header := protocol.PARALLEL_PREFIX + encrypted(t, fmt.Sprintf("file.bin\n%d\n4", 8*receiver.MIN_PART_SIZE), testKey)
+	if reply := exchange(t, addr, fromLine(t, testKey), header); !strings.HasPrefix(reply, protocol.REJECT_PREFIX+protocol.REJECT_UNSUPPORTED+" ") {
+		t.Errorf("reply = %q, want REJECT:%s", reply, protocol.REJECT_UNSUPPORTED)
+	}
+	// The sender sends the file again over one connection; the user must only be asked then
+	if asked := asked.Load(); asked != 0 {
+		t.Errorf("receiver asked %d times before refusing", asked)
+	}
+}
+
+func TestBusyRejectsAreBounded(t *testing.T) {
+	server := receivertest.Start(t, testKey, receiver.WithLimits(receiver.Limits{MaxConnections: 1}))
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	// Take the only slot
+	dial()
+	for server.ActiveConnections() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A flood of connections that never send anything
+	flood := make([]net.Conn, 3*receiver.MAX_BUSY_REJECTS)
+	for i := range flood {
+		flood[i] = dial()
+	}
+	told := 0
+	for i, conn := range flood {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		switch {
+		case strings.HasPrefix(reply, protocol.REJECT_PREFIX+protocol.REJECT_LIMIT+" "):
+			told++
+		case reply != "" || errors.Is(err, os.ErrDeadlineExceeded):
+			t.Errorf("connection %d got %q, %v, want REJECT:%s or to be closed", i, reply, err, protocol.REJECT_LIMIT)
+		}
+	}
+	if told == 0 || told > receiver.MAX_BUSY_REJECTS {
+		t.Errorf("%d of %d connections over the limit were told the receiver is busy, want 1 to %d",
+			told, len(flood), receiver.MAX_BUSY_REJECTS)
+	}
+}
@@ -0,0 +1,148 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"local-share/pkg/protocol"
+)
+
+// ErrNotShared is returned by a Share for paths outside of it or that do not exist
+var ErrNotShared = errors.New("not found in share")
+
+// Share offers files for senders to list and download
+type Share interface {
+	// List returns the entries of the directory dir, "" for the top of the share
+	List(dir string) ([]protocol.Entry, error)
+	// Open opens the file name for reading and returns its size
+	Open(name string) (io.ReadCloser, int64, error)
+}
+
+// DirShare shares a local directory read-only. Hidden files (starting with ".") and
+// anything a symbolic link leads to outside the directory are not shared.
+type DirShare struct {
+	dir string
+}
+
+// NewDirShare returns a share of dir
+func NewDirShare(dir string) (*DirShare, error) {
+	dir, err := filepath.Abs(dir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &DirShare{dir: dir}, nil
+}
+
+// Dir returns the shared directory
+func (d *DirShare) Dir() string {
+	return d.dir
+}
+
+// List implements Share. Directories come first, each group sorted by name.
+func (d *DirShare) List(dir string) ([]protocol.Entry, error) {
+	local, err := d.resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(local)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotShared, dir)
+	}
+
+	entries := []protocol.Entry{}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		// Follow symbolic links, but only to what is inside the share
+		info, err := os.Stat(filepath.Join(local, name))
+		if err != nil || !d.inside(filepath.Join(local, name)) {
+			continue
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		entries = append(entries, protocol.Entry{
+			Name:    path.Join(cleanSharePath(dir), name),
+			Size:    info.Size(),
+			Dir:     info.IsDir(),
+			ModTime: info.ModTime().UTC(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// Open implements Share
+func (d *DirShare) Open(name string) (io.ReadCloser, int64, error) {
+	local, err := d.resolve(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := os.Open(local)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotShared, name)
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, fmt.Errorf("%s is not a file", name)
+	}
+	return file, info.Size(), nil
+}
+
+// resolve turns a share path into a local path, refusing anything outside the share
+func (d *DirShare) resolve(name string) (string, error) {
+	clean := cleanSharePath(name)
+	if clean == "" {
+		return d.dir, nil
+	}
+	for _, element := range strings.Split(clean, "/") {
+		if element == ".." || strings.HasPrefix(element, ".") {
+			return "", fmt.Errorf("%w: %s", ErrNotShared, name)
+		}
+	}
+	local := filepath.Join(d.dir, filepath.FromSlash(clean))
+	if !filepath.IsLocal(filepath.FromSlash(clean)) || !d.inside(local) {
+		return "", fmt.Errorf("%w: %s", ErrNotShared, name)
+	}
+	return local, nil
+}
+
+// inside reports whether local, with symbolic links resolved, is within the share
+func (d *DirShare) inside(local string) bool {
+	real, err := filepath.EvalSymlinks(local)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(d.dir, real)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// cleanSharePath normalizes a "/"-separated share path: no leading or trailing slashes, "" for the top
+func cleanSharePath(name string) string {
+	clean := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	return strings.TrimPrefix(clean, "/")
+}
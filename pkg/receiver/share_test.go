@@ -0,0 +1,148 @@
+package receiver_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"local-share/pkg/protocol"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+	"local-share/pkg/sender"
+)
+
+// writeFiles creates files below dir, with their names as content
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// startShare serves a share of files next to a directory outside of it and returns the receiver's address
+// and the outside directory. The share links to files inside and outside.
+func startShare(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	dir, outside := filepath.Join(root, "share"), filepath.Join(root, "outside")
+	writeFiles(t, dir, "report.txt", "sub/notes.txt", ".hidden", "sub/.env", ".git/config")
+	writeFiles(t, outside, "secret.txt")
+	links := map[string]string{
+		"escape":      filepath.Join(outside, "secret.txt"),
+		"escape-dir":  outside,
+		"escape-rel":  "../outside/secret.txt",
+		"sub/up":      "..",
+		"inner":       "sub/notes.txt",
+		"dangling":    "missing.txt",
+		"sub/to-root": "/",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+	share, err := receiver.NewDirShare(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receivertest.Start(t, testKey, receiver.WithShare(share)).Addr, outside
+}
+
+func TestShareGet(t *testing.T) {
+	addr, outside := startShare(t)
+	client := sender.NewClient(sender.WithKey(testKey))
+
+	fetchable := map[string]string{
+		"report.txt":           "report.txt",
+		"sub/notes.txt":        "sub/notes.txt",
+		"/report.txt":          "report.txt", // Share paths are relative to the share
+		`sub\notes.txt`:        "sub/notes.txt",
+		"sub/../report.txt":    "report.txt",
+		"../report.txt":        "report.txt", // Cannot go above the top
+		"inner":                "sub/notes.txt",
+		"sub/up/report.txt":    "report.txt",
+		"./sub/./notes.txt":    "sub/notes.txt",
+		"sub//notes.txt":       "sub/notes.txt",
+		"sub/notes.txt/":       "sub/notes.txt",
+		"/../../sub/notes.txt": "sub/notes.txt",
+	}
+	for name, want := range fetchable {
+		dest := filepath.Join(t.TempDir(), "download")
+		if _, err := client.Get(context.Background(), addr, name, dest); err != nil {
+			t.Errorf("Get(%q) failed: %v", name, err)
+			continue
+		}
+		if got, err := os.ReadFile(dest); err != nil || string(got) != want {
+			t.Errorf("Get(%q) = %q, %v, want the content of %s", name, got, err, want)
+		}
+	}
+
+	refused := []string{
+		"../outside/secret.txt",
+		"sub/../../outside/secret.txt",
+		`..\outside\secret.txt`,
+		filepath.Join(outside, "secret.txt"),
+		"/etc/passwd",
+		".hidden",
+		"sub/.env",
+		".git/config",
+		"escape",
+		"escape-dir/secret.txt",
+		"escape-rel",
+		"sub/to-root/etc/passwd",
+		"dangling",
+		"missing.txt",
+		"sub",
+		"",
+	}
+	for _, name := range refused {
+		dest := filepath.Join(t.TempDir(), "download")
+		_, err := client.Get(context.Background(), addr, name, dest)
+		var reject *protocol.RejectError
+		if !errors.Is(err, sender.ErrRejected) || !errors.As(err, &reject) || reject.Code != protocol.REJECT_INVALID {
+			t.Errorf("Get(%q) = %v, want it refused as invalid", name, err)
+		}
+		if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Get(%q) left a file behind", name)
+		}
+	}
+}
+
+func TestShareList(t *testing.T) {
+	addr, outside := startShare(t)
+	client := sender.NewClient(sender.WithKey(testKey))
+	names := func(dir string) ([]string, error) {
+		entries, err := client.List(context.Background(), addr, dir)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names, err
+	}
+
+	// Hidden files and links leading outside are left out; directories come first
+	top := []string{"sub", "inner", "report.txt"}
+	for _, dir := range []string{"", "/", ".", "..", "../.."} {
+		if got, err := names(dir); err != nil || !slices.Equal(got, top) {
+			t.Errorf("List(%q) = %q, %v, want %q", dir, got, err, top)
+		}
+	}
+	if got, err := names("sub"); err != nil || !slices.Equal(got, []string{"sub/up", "sub/notes.txt"}) {
+		t.Errorf("List(sub) = %q, %v", got, err)
+	}
+
+	for _, dir := range []string{"escape-dir", "../outside", outside, ".git", "sub/to-root", "sub/to-root/etc", "report.txt", "missing"} {
+		if got, err := names(dir); !errors.Is(err, sender.ErrRejected) {
+			t.Errorf("List(%q) = %q, %v, want it refused", dir, got, err)
+		}
+	}
+}
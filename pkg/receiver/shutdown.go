@@ -0,0 +1,93 @@
+package receiver
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats counts what the server handled
+type Stats struct {
+	Files    int64 // Files stored
+	Texts    int64 // Text messages received
+	Bytes    int64 // Bytes of file content stored
+	Rejected int64 // Transfers refused (authentication, limits, approval)
+	Failed   int64 // Transfers that broke off or could not be stored
+
+	Served      int64 // Files downloaded from the share
+	ServedBytes int64 // Bytes of file content downloaded from the share
+}
+
+// stats is the concurrently updated form of Stats
+type stats struct {
+	files    atomic.Int64
+	texts    atomic.Int64
+	bytes    atomic.Int64
+	failed   atomic.Int64
+	rejected atomic.Int64
+
+	served      atomic.Int64
+	servedBytes atomic.Int64
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Files:    s.files.Load(),
+		Texts:    s.texts.Load(),
+		Bytes:    s.bytes.Load(),
+		Rejected: s.rejected.Load(),
+		Failed:   s.failed.Load(),
+
+		Served:      s.served.Load(),
+		ServedBytes: s.servedBytes.Load(),
+	}
+}
+
+// connTracker keeps the open connections so they can be drained or closed on shutdown
+type connTracker struct {
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (t *connTracker) add(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[conn] = struct{}{}
+	t.wg.Add(1)
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+	t.wg.Done()
+}
+
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// closeAll aborts every connection that is still open
+func (t *connTracker) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+}
+
+// idle returns a channel that is closed once every connection has finished
+func (t *connTracker) idle() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	return done
+}
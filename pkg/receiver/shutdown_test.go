@@ -0,0 +1,134 @@
+package receiver_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/protocol"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+)
+
+// beginUpload starts sending content as name and stops halfway. It returns the connection,
+// its reader and the encrypted content that was not sent yet.
+func beginUpload(t *testing.T, server *receivertest.Receiver, name string, content []byte) (net.Conn, *bufio.Reader, []byte) {
+	t.Helper()
+	var data bytes.Buffer
+	writer, err := crypto.NewEncryptWriter(&data, []byte(testKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(content)
+	writer.Close()
+
+	conn, err := net.Dial("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "%s\n%s\n%d\n", fromLine(t, testKey), protocol.FILE_PREFIX+encrypted(t, name, testKey), data.Len())
+	reader := bufio.NewReader(conn)
+	if reply, err := reader.ReadString('\n'); strings.TrimSpace(reply) != protocol.ACCEPT {
+		t.Fatalf("reply = %q, %v, want %s", reply, err, protocol.ACCEPT)
+	}
+	half := data.Len() / 2
+	if _, err := conn.Write(data.Bytes()[:half]); err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, data.Bytes()[half:]
+}
+
+// tempFiles returns the names of the temporary files in dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, receiver.TEMP_FILE_PREFIX+"*"+receiver.TEMP_FILE_SUFFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, match := range matches {
+		matches[i] = filepath.Base(match)
+	}
+	return matches
+}
+
+func TestShutdownDrainsTransfers(t *testing.T) {
+	server := receivertest.Start(t, testKey)
+	content := bytes.Repeat([]byte("local-share "), 10000)
+	conn, reader, rest := beginUpload(t, server, "file.txt", content)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+
+	// New connections are refused while the transfer goes on
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		probe, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			break
+		}
+		probe.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("receiver still accepts connections after Shutdown")
+		}
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the transfer finished", err)
+	default:
+	}
+
+	if _, err := conn.Write(rest); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := reader.ReadString('\n'); !strings.HasPrefix(reply, protocol.DONE_PREFIX) {
+		t.Fatalf("reply = %q, %v, want DONE", reply, err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v, want nil after the transfer finished", err)
+	}
+	if stored, err := os.ReadFile(filepath.Join(server.Dir, "file.txt")); err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("stored file: %d bytes, %v; want %d bytes", len(stored), err, len(content))
+	}
+	if temps := tempFiles(t, server.Dir); len(temps) != 0 {
+		t.Errorf("temporary files left: %v", temps)
+	}
+}
+
+func TestShutdownTimeoutAbortsTransfers(t *testing.T) {
+	server := receivertest.Start(t, testKey)
+	// A transfer of another receiver writing into the same directory
+	other := receiver.TEMP_FILE_PREFIX + "other" + receiver.TEMP_FILE_SUFFIX
+	if err := os.WriteFile(filepath.Join(server.Dir, other), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	beginUpload(t, server, "file.txt", bytes.Repeat([]byte("local-share "), 10000))
+	for len(tempFiles(t, server.Dir)) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if temps := tempFiles(t, server.Dir); len(temps) != 1 || temps[0] != other {
+		t.Errorf("temporary files left: %v, want only %s", temps, other)
+	}
+	if _, err := os.Stat(filepath.Join(server.Dir, "file.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("aborted transfer was stored: %v", err)
+	}
+}
@@ -0,0 +1,473 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"local-share/pkg/progress"
+)
+
+const (
+	DEFAULT_UPLOAD_DIR = "uploads"
+	TEMP_FILE_PREFIX   = ".local-share-"
+	TEMP_FILE_SUFFIX   = ".part"
+	DEFAULT_LAYOUT     = "{name}" // Files directly in the upload directory
+
+	// Temporary files untouched this long are left over from a receiver that was killed,
+	// younger ones may belong to another receiver writing into the same directory
+	STALE_TEMP_FILE_AGE = time.Hour
+
+	// What DirStorage does when a file with the same name already exists
+	CONFLICT_OVERWRITE = "overwrite" // Replace the existing file
+	CONFLICT_RENAME    = "rename"    // Store as "name (1).ext", "name (2).ext", ...
+	CONFLICT_REJECT    = "reject"    // Refuse the transfer
+)
+
+// ErrFileExists is returned by Storage.Create when a file may not be replaced
+var ErrFileExists = errors.New("file already exists")
+
+// Storage decides where received files are written
+type Storage interface {
+	// Reserve checks that size more bytes can be stored and holds that space until release is called
+	Reserve(size int64) (release func(), err error)
+	// Create starts a new file that becomes visible once committed
+	Create(file IncomingFile) (Upload, error)
+}
+
+// IncomingFile describes a file about to be received
+type IncomingFile struct {
+	Name       string    // Filename chosen by the sender
+	Device     string    // Device name of the sender
+	RemoteAddr string    // Network address of the sender
+	Time       time.Time // When the transfer started
+}
+
+// Upload is a file being received
+type Upload interface {
+	io.Writer
+	// Commit completes the file and returns the path it was stored at
+	Commit() (string, error)
+	// Abort discards everything written so far
+	Abort() error
+}
+
+// DirStorage stores files in a local directory, enforcing free disk space and an optional quota.
+// Space for transfers in progress is reserved so concurrent uploads cannot oversubscribe the disk.
+// With a quota the directory is measured once when the storage is created and kept up to date
+// as files are stored and removed; changes made by others are only noticed after a restart.
+type DirStorage struct {
+	dir      string
+	quota    int64  // Maximum total size of the directory, 0 for no quota
+	conflict string // One of the CONFLICT_* policies
+	layout   string // Path template below dir, see SetLayout
+
+	mu       sync.Mutex
+	reserved int64
+	used     int64               // Bytes stored in the directory, only measured with a quota
+	temps    map[string]struct{} // Temporary files of uploads in progress
+}
+
+// NewDirStorage creates dir if needed and returns a storage writing into it
+func NewDirStorage(dir string, quota int64) (*DirStorage, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload directory: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating upload directory: %v", err)
+	}
+	storage := &DirStorage{dir: dir, quota: quota, conflict: CONFLICT_OVERWRITE, layout: DEFAULT_LAYOUT}
+	if quota > 0 {
+		if storage.used, err = dirSize(dir); err != nil {
+			return nil, fmt.Errorf("cannot check upload quota: %v", err)
+		}
+	}
+	return storage, nil
+}
+
+// SetConflictPolicy sets what happens when a received file already exists (default overwrite)
+func (d *DirStorage) SetConflictPolicy(policy string) error {
+	switch policy {
+	case CONFLICT_OVERWRITE, CONFLICT_RENAME, CONFLICT_REJECT:
+		d.conflict = policy
+		return nil
+	}
+	return fmt.Errorf("invalid conflict policy %q (use overwrite, rename or reject)", policy)
+}
+
+// SetLayout sets where files are stored below the directory. The layout is a
+// slash-separated path template whose last element contains {name}, e.g.
+// "{date}/{sender}/{name}". Placeholders:
+//
+//	{name}    filename chosen by the sender
+//	{sender}  device name of the sender
+//	{ip}      IP address of the sender
+//	{date}    date the transfer started, 2006-01-02
+//	{year}    year, 2006
+//	{month}   month, 01
+func (d *DirStorage) SetLayout(layout string) error {
+	if err := ValidateLayout(layout); err != nil {
+		return err
+	}
+	d.layout = layout
+	return nil
+}
+
+// ValidateLayout checks a layout template for SetLayout
+func ValidateLayout(layout string) error {
+	elements := strings.Split(layout, "/")
+	for i, element := range elements {
+		if element == "" || element == "." || element == ".." {
+			return fmt.Errorf("invalid layout %q: empty, \".\" or \"..\" path element", layout)
+		}
+		rest := element
+		for {
+			start := strings.Index(rest, "{")
+			if start < 0 {
+				break
+			}
+			end := strings.Index(rest[start:], "}")
+			if end < 0 {
+				return fmt.Errorf("invalid layout %q: unterminated placeholder", layout)
+			}
+			placeholder := rest[start+1 : start+end]
+			if _, ok := layoutValue(placeholder, IncomingFile{}); !ok {
+				return fmt.Errorf("invalid layout %q: unknown placeholder {%s} (use name, sender, ip, date, year or month)", layout, placeholder)
+			}
+			if placeholder == "name" && i != len(elements)-1 {
+				return fmt.Errorf("invalid layout %q: {name} must be in the last path element", layout)
+			}
+			rest = rest[start+end+1:]
+		}
+	}
+	if !strings.Contains(elements[len(elements)-1], "{name}") {
+		return fmt.Errorf("invalid layout %q: the last path element must contain {name}", layout)
+	}
+	return nil
+}
+
+// layoutPath expands the layout for file into a path relative to the directory
+func (d *DirStorage) layoutPath(file IncomingFile) string {
+	elements := strings.Split(d.layout, "/")
+	for i, element := range elements {
+		var b strings.Builder
+		for {
+			start := strings.Index(element, "{")
+			if start < 0 {
+				break
+			}
+			end := start + strings.Index(element[start:], "}")
+			value, _ := layoutValue(element[start+1:end], file)
+			b.WriteString(element[:start])
+			b.WriteString(value)
+			element = element[end+1:]
+		}
+		b.WriteString(element)
+		elements[i] = cleanElement(b.String())
+	}
+	return filepath.Join(elements...)
+}
+
+// layoutValue returns the value of a layout placeholder for file
+func layoutValue(placeholder string, file IncomingFile) (string, bool) {
+	switch placeholder {
+	case "name":
+		return file.Name, true
+	case "sender":
+		return file.Device, true
+	case "ip":
+		host, _, err := net.SplitHostPort(file.RemoteAddr)
+		if err != nil {
+			host = file.RemoteAddr
+		}
+		return host, true
+	case "date":
+		return file.Time.Format("2006-01-02"), true
+	case "year":
+		return file.Time.Format("2006"), true
+	case "month":
+		return file.Time.Format("01"), true
+	}
+	return "", false
+}
+
+// cleanElement makes an expanded layout element safe to use as a single path element:
+// separators and control characters are replaced, and an empty or dot-only element becomes "unknown"
+func cleanElement(element string) string {
+	element = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, element)
+	if strings.Trim(element, ". ") == "" {
+		return "unknown"
+	}
+	return element
+}
+
+// Layout returns the path template of received files, see SetLayout
+func (d *DirStorage) Layout() string {
+	return d.layout
+}
+
+// Dir returns the directory files are stored in
+func (d *DirStorage) Dir() string {
+	return d.dir
+}
+
+// Reserve implements Storage
+func (d *DirStorage) Reserve(size int64) (func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	free, err := freeSpace(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check free disk space: %v", err)
+	}
+	if size > free-d.reserved {
+		return nil, fmt.Errorf("not enough free disk space (need %s, %s available)",
+			progress.FormatSize(size), progress.FormatSize(max(free-d.reserved, 0)))
+	}
+
+	if d.quota > 0 && d.used+d.reserved+size > d.quota {
+		return nil, fmt.Errorf("upload quota exceeded (%s used of %s, need %s)",
+			progress.FormatSize(d.used+d.reserved), progress.FormatSize(d.quota), progress.FormatSize(size))
+	}
+
+	d.reserved += size
+	released := false
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if !released {
+			d.reserved -= size
+			released = true
+		}
+	}, nil
+}
+
+// Create implements Storage. Data goes to a temporary file first so an interrupted
+// transfer never leaves a partial file behind.
+func (d *DirStorage) Create(file IncomingFile) (Upload, error) {
+	// Only keep the base name so a sender cannot write outside the directory
+	name := filepath.Base(file.Name)
+	if name == "." || name == ".." || name == string(filepath.Separator) || isTempFile(name) {
+		return nil, fmt.Errorf("invalid filename %q", name)
+	}
+	if file.Time.IsZero() {
+		file.Time = time.Now()
+	}
+	file.Name = name
+	path := filepath.Join(d.dir, d.layoutPath(file))
+	if isTempFile(filepath.Base(path)) {
+		return nil, fmt.Errorf("invalid filename %q", name)
+	}
+	if d.conflict == CONFLICT_REJECT && exists(path) {
+		return nil, fmt.Errorf("%w: %s", ErrFileExists, name)
+	}
+
+	tempFile, err := d.createTemp()
+	if err != nil {
+		return nil, err
+	}
+	return &dirUpload{storage: d, file: tempFile, path: path}, nil
+}
+
+// CreateRandomAccess implements ParallelStorage
+func (d *DirStorage) CreateRandomAccess(file IncomingFile) (RandomAccessUpload, error) {
+	upload, err := d.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	return upload.(*dirUpload), nil
+}
+
+// createTemp creates the temporary file of an upload and remembers it for RemoveTempFiles
+func (d *DirStorage) createTemp() (*os.File, error) {
+	tempFile, err := os.CreateTemp(d.dir, TEMP_FILE_PREFIX+"*"+TEMP_FILE_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	tempFile.Chmod(0644)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.temps == nil {
+		d.temps = make(map[string]struct{})
+	}
+	d.temps[tempFile.Name()] = struct{}{}
+	return tempFile, nil
+}
+
+// forgetTemp is called once an upload's temporary file was renamed or removed
+func (d *DirStorage) forgetTemp(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.temps, name)
+}
+
+// RemoveTempFiles deletes the temporary files of uploads created by this storage that did not
+// complete. Files of other receivers writing into the same directory are left alone.
+func (d *DirStorage) RemoveTempFiles() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	removed := 0
+	for name := range d.temps {
+		if err := os.Remove(name); err == nil {
+			removed++
+		}
+		delete(d.temps, name)
+	}
+	return removed
+}
+
+// RemoveStaleTempFiles deletes temporary files that were not written to for STALE_TEMP_FILE_AGE,
+// left behind by a receiver that was killed during a transfer
+func (d *DirStorage) RemoveStaleTempFiles() int {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !isTempFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < STALE_TEMP_FILE_AGE {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.dir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// dirUpload writes to a temporary file that is renamed into place on commit
+type dirUpload struct {
+	storage *DirStorage
+	file    *os.File
+	path    string
+	replace bool // Replace an existing file regardless of the conflict policy
+}
+
+func (u *dirUpload) Write(p []byte) (int, error) {
+	return u.file.Write(p)
+}
+
+func (u *dirUpload) WriteAt(p []byte, offset int64) (int, error) {
+	return u.file.WriteAt(p, offset)
+}
+
+func (u *dirUpload) ReadAt(p []byte, offset int64) (int, error) {
+	return u.file.ReadAt(p, offset)
+}
+
+func (u *dirUpload) Commit() (string, error) {
+	defer u.storage.forgetTemp(u.file.Name())
+	info, err := u.file.Stat()
+	if err == nil {
+		err = u.file.Close()
+	}
+	if err != nil {
+		u.file.Close()
+		os.Remove(u.file.Name())
+		return "", err
+	}
+
+	// Pick the final name and move the file there in one step, so concurrent uploads
+	// of the same name cannot both claim it
+	u.storage.mu.Lock()
+	defer u.storage.mu.Unlock()
+	path := u.path
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		os.Remove(u.file.Name())
+		return "", err
+	}
+	switch {
+	case u.replace:
+	case u.storage.conflict == CONFLICT_REJECT:
+		if exists(path) {
+			os.Remove(u.file.Name())
+			return "", fmt.Errorf("%w: %s", ErrFileExists, filepath.Base(path))
+		}
+	case u.storage.conflict == CONFLICT_RENAME:
+		path = freeName(path)
+	}
+	var replaced int64
+	if old, err := os.Lstat(path); err == nil && old.Mode().IsRegular() {
+		replaced = old.Size()
+	}
+	if err := os.Rename(u.file.Name(), path); err != nil {
+		os.Remove(u.file.Name())
+		return "", err
+	}
+	u.storage.used += info.Size() - replaced
+	return path, nil
+}
+
+func (u *dirUpload) Abort() error {
+	defer u.storage.forgetTemp(u.file.Name())
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// freeName returns path, or "name (n).ext" with the lowest n that does not exist yet
+func freeName(path string) string {
+	if !exists(path) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !exists(candidate) {
+			return candidate
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// dirSize returns the total size of the regular files below dir.
+// Temporary files of transfers in progress are skipped because their space is already reserved.
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if isTempFile(entry.Name()) {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// isTempFile reports whether name belongs to a transfer that has not completed yet
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, TEMP_FILE_PREFIX) && strings.HasSuffix(name, TEMP_FILE_SUFFIX)
+}
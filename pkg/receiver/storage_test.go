@@ -0,0 +1,245 @@
+package receiver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// store receives content as name into storage
+func store(t *testing.T, storage *DirStorage, name, content string) string {
+	t.Helper()
+	upload, err := storage.Create(IncomingFile{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	path, err := upload.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestQuota(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "existing"), make([]byte, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewDirStorage(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reserve := func(size int64, wantOK bool) {
+		t.Helper()
+		release, err := storage.Reserve(size)
+		if wantOK != (err == nil) {
+			t.Fatalf("Reserve(%d) with %d used = %v, want ok %v", size, storage.used, err, wantOK)
+		}
+		if release != nil {
+			release()
+		}
+	}
+
+	// Files present at start-up count
+	reserve(61, false)
+	reserve(60, true)
+
+	// Reservations count until released
+	release, err := storage.Reserve(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserve(11, false)
+	release()
+	release() // Releasing twice must not free the space twice
+	reserve(60, true)
+
+	// Stored files count, replaced files no longer do
+	store(t, storage, "a", strings.Repeat("a", 30))
+	reserve(31, false)
+	store(t, storage, "a", strings.Repeat("a", 10))
+	reserve(50, true)
+	reserve(51, false)
+
+	// Renamed copies count on their own
+	if err := storage.SetConflictPolicy(CONFLICT_RENAME); err != nil {
+		t.Fatal(err)
+	}
+	store(t, storage, "a", strings.Repeat("a", 10))
+	reserve(40, true)
+	reserve(41, false)
+
+	// Removed files free their space
+	if err := storage.Remove("existing"); err != nil {
+		t.Fatal(err)
+	}
+	reserve(80, true)
+	reserve(81, false)
+
+	if used, err := dirSize(dir); err != nil || used != storage.used {
+		t.Errorf("tracked usage %d, directory holds %d (%v)", storage.used, used, err)
+	}
+}
+
+func TestAbortedUploadDoesNotCount(t *testing.T) {
+	storage, err := NewDirStorage(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := storage.Create(IncomingFile{Name: "partial"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Write(make([]byte, 60))
+	if err := upload.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if storage.used != 0 {
+		t.Errorf("aborted upload counted %d bytes", storage.used)
+	}
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewDirStorage(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewDirStorage(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(storage *DirStorage, name string) Upload {
+		t.Helper()
+		upload, err := storage.Create(IncomingFile{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return upload
+	}
+	pending := []*dirUpload{create(storage, "a").(*dirUpload), create(storage, "b").(*dirUpload)}
+	create(storage, "committed").Commit()
+	create(storage, "aborted").Abort()
+	others := create(other, "other").(*dirUpload)
+	stale := filepath.Join(dir, TEMP_FILE_PREFIX+"killed"+TEMP_FILE_SUFFIX)
+	if err := os.WriteFile(stale, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-STALE_TEMP_FILE_AGE - time.Minute)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the uploads of this storage that did not complete are removed
+	if removed := storage.RemoveTempFiles(); removed != len(pending) {
+		t.Errorf("RemoveTempFiles removed %d files, want %d", removed, len(pending))
+	}
+	for _, upload := range pending {
+		if exists(upload.file.Name()) {
+			t.Errorf("%s was not removed", upload.file.Name())
+		}
+	}
+	if !exists(others.file.Name()) || !exists(stale) {
+		t.Fatal("RemoveTempFiles removed files of another storage")
+	}
+
+	// At startup only files nobody wrote to for a while are left over
+	if removed := other.RemoveStaleTempFiles(); removed != 1 {
+		t.Errorf("RemoveStaleTempFiles removed %d files, want 1", removed)
+	}
+	if exists(stale) || !exists(others.file.Name()) {
+		t.Errorf("RemoveStaleTempFiles removed the wrong file")
+	}
+}
+
+func TestLayoutKeepsFilesInside(t *testing.T) {
+	received := time.Date(2026, 3, 7, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		layout string
+		device string
+		name   string
+		want   string // Path below the directory, with slashes
+	}{
+		{"{name}", "laptop", "report.pdf", "report.pdf"},
+		{"{date}/{sender}/{name}", "laptop", "report.pdf", "2026-03-07/laptop/report.pdf"},
+		{"{year}/{month}/{ip}-{name}", "laptop", "report.pdf", "2026/03/192.168.1.20-report.pdf"},
+
+		{"{sender}/{name}", "", "report.pdf", "unknown/report.pdf"},
+		{"{sender}/{name}", "..", "report.pdf", "unknown/report.pdf"},
+		{"{sender}/{name}", ".", "report.pdf", "unknown/report.pdf"},
+		{"{sender}/{name}", " . . ", "report.pdf", "unknown/report.pdf"},
+		{"{sender}/{name}", "../../etc", "report.pdf", ".._.._etc/report.pdf"},
+		{"{sender}/{name}", "/etc", "report.pdf", "_etc/report.pdf"},
+		{"{sender}/{name}", `..\..\Windows`, "report.pdf", ".._.._Windows/report.pdf"},
+		{"{sender}/{name}", "a/../../b", "report.pdf", "a_.._.._b/report.pdf"},
+		{"{sender}/{name}", "line\nbreak\x00", "report.pdf", "line_break_/report.pdf"},
+		{"{sender}{sender}/{name}", "..", "report.pdf", "unknown/report.pdf"},
+		{"in-{sender}/{name}", "..", "report.pdf", "in-../report.pdf"},
+
+		{"{sender}/{name}", "laptop", "../../etc/passwd", "laptop/passwd"},
+		{"{sender}/{name}", "laptop", `..\..\boot.ini`, `laptop/.._.._boot.ini`},
+		{"{name}.bak", "laptop", "report", "report.bak"},
+	}
+	for _, test := range tests {
+		t.Run(test.layout+" "+test.device+" "+test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			storage, err := NewDirStorage(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.SetLayout(test.layout); err != nil {
+				t.Fatal(err)
+			}
+			upload, err := storage.Create(IncomingFile{Name: test.name, Device: test.device, RemoteAddr: "192.168.1.20:50000", Time: received})
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, err := upload.Commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rel, err := filepath.Rel(storage.Dir(), path)
+			if err != nil || !filepath.IsLocal(rel) {
+				t.Fatalf("stored at %s, outside %s", path, storage.Dir())
+			}
+			if got := filepath.ToSlash(rel); got != test.want {
+				t.Errorf("stored at %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateLayout(t *testing.T) {
+	valid := []string{"{name}", "{date}/{sender}/{name}", "{year}/{month}/{ip}-{name}", "inbox/{name}.txt"}
+	for _, layout := range valid {
+		if err := ValidateLayout(layout); err != nil {
+			t.Errorf("ValidateLayout(%q) = %v", layout, err)
+		}
+	}
+	invalid := map[string]string{
+		"":                  "empty",
+		"{sender}":          "must contain {name}",
+		"{name}/{sender}":   "{name} must be in the last",
+		"../{name}":         `".."`,
+		"./{name}":          `"."`,
+		"a//{name}":         "empty",
+		"/{name}":           "empty",
+		"{sender}/{name}/":  "{name} must be in the last",
+		"{nope}/{name}":     "unknown placeholder {nope}",
+		"{sender/{name}":    "unterminated placeholder",
+		"{name":             "unterminated placeholder",
+		"{sender}/{name}{":  "unterminated placeholder",
+		"{date}-{name}-{x}": "unknown placeholder {x}",
+	}
+	for layout, want := range invalid {
+		if err := ValidateLayout(layout); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ValidateLayout(%q) = %v, want an error containing %q", layout, err, want)
+		}
+	}
+}
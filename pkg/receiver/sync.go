@@ -0,0 +1,192 @@
+package receiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"local-share/pkg/protocol"
+)
+
+// SyncStorage is a Storage that senders can keep a folder in sync with.
+// Paths use "/" as separator and start with the sync target, e.g. "build/bin/app".
+type SyncStorage interface {
+	Storage
+	// Manifest returns the files below target with their checksums, named relative to target
+	Manifest(target string) ([]protocol.Entry, error)
+	// CreateAt starts a file that replaces whatever is stored at name once committed
+	CreateAt(name string) (Upload, error)
+	// Remove deletes the file at name
+	Remove(name string) error
+}
+
+// DeletedFile describes a file a sender removed while synchronizing
+type DeletedFile struct {
+	RemoteAddr string
+	Device     string
+	Name       string // Sync path of the file
+}
+
+// DeleteHandler is called for every file removed by a sender
+type DeleteHandler func(file DeletedFile)
+
+// Manifest implements SyncStorage. A target that does not exist yet has no files.
+// Symbolic links and files of transfers in progress are left out.
+func (d *DirStorage) Manifest(target string) ([]protocol.Entry, error) {
+	root, err := d.syncPath(target)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []protocol.Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", cleanSharePath(target))
+	}
+
+	entries := []protocol.Entry{}
+	err = filepath.WalkDir(root, func(local string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || isTempFile(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		checksum, err := fileChecksum(local)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, local)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, protocol.Entry{
+			Name:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			SHA256:  checksum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// CreateAt implements SyncStorage. The layout and conflict policy do not apply:
+// the file always replaces the one at name.
+func (d *DirStorage) CreateAt(name string) (Upload, error) {
+	local, err := d.syncPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if local == d.dir {
+		return nil, fmt.Errorf("invalid filename %q", name)
+	}
+
+	tempFile, err := d.createTemp()
+	if err != nil {
+		return nil, err
+	}
+	return &dirUpload{storage: d, file: tempFile, path: local, replace: true}, nil
+}
+
+// Remove implements SyncStorage. Directories left empty are removed as well.
+func (d *DirStorage) Remove(name string) error {
+	local, err := d.syncPath(name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(local)
+	if err != nil {
+		return fmt.Errorf("%s does not exist", cleanSharePath(name))
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file", cleanSharePath(name))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Remove(local); err != nil {
+		return err
+	}
+	d.used -= info.Size()
+	for dir := filepath.Dir(local); dir != d.dir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// syncPath turns a sync path into a local path, refusing anything outside the directory.
+// Directories on the way must not be symbolic links leading elsewhere.
+func (d *DirStorage) syncPath(name string) (string, error) {
+	clean := cleanSharePath(name)
+	if clean == "" {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	for _, element := range strings.Split(clean, "/") {
+		if element == ".." || isTempFile(element) {
+			return "", fmt.Errorf("invalid path %q", name)
+		}
+	}
+	if !filepath.IsLocal(filepath.FromSlash(clean)) {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+
+	local := filepath.Join(d.dir, filepath.FromSlash(clean))
+	real, err := filepath.EvalSymlinks(d.dir)
+	if err != nil {
+		return "", err
+	}
+	// Check the deepest directory that exists already
+	for parent := path.Dir(clean); parent != "."; parent = path.Dir(parent) {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(d.dir, filepath.FromSlash(parent)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if rel, err := filepath.Rel(real, resolved); err != nil || !filepath.IsLocal(rel) {
+			return "", fmt.Errorf("invalid path %q", name)
+		}
+		break
+	}
+	return local, nil
+}
+
+// fileChecksum returns the SHA-256 of the file at local, hex encoded
+func fileChecksum(local string) (string, error) {
+	file, err := os.Open(local)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.CopyBuffer(hash, file, make([]byte, BUFFER_SIZE)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,205 @@
+package receiver
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"local-share/pkg/protocol"
+)
+
+// syncStorage returns storage in a directory next to a directory outside of it, holding secret.
+// The storage has a link to the outside directory and one to the secret.
+func syncStorage(t *testing.T) (*DirStorage, string) {
+	t.Helper()
+	root := t.TempDir()
+	dir, outside := filepath.Join(root, "uploads"), filepath.Join(root, "outside")
+	for _, d := range []string{filepath.Join(dir, "inside"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"link": outside, "escape": filepath.Join(outside, "secret"), "inlink": "inside"} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+	storage, err := NewDirStorage(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage, outside
+}
+
+// storeAt receives content at the sync path name
+func storeAt(t *testing.T, storage *DirStorage, name, content string) {
+	t.Helper()
+	upload, err := storage.CreateAt(name)
+	if err != nil {
+		t.Fatalf("CreateAt(%q) failed: %v", name, err)
+	}
+	if _, err := upload.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncPath(t *testing.T) {
+	storage, _ := syncStorage(t)
+	valid := map[string]string{
+		"build/app":         "build/app",
+		"/build/app":        "build/app",
+		`build\bin\app`:     "build/bin/app",
+		"build/./app":       "build/app",
+		"build//app/":       "build/app",
+		"build/../app":      "app",
+		"../../app":         "app", // Cleaned to the top of the directory
+		"inlink/app":        "inlink/app",
+		"inside/new/deeper": "inside/new/deeper",
+	}
+	for name, want := range valid {
+		local, err := storage.syncPath(name)
+		if err != nil {
+			t.Errorf("syncPath(%q) failed: %v", name, err)
+			continue
+		}
+		if rel, err := filepath.Rel(storage.Dir(), local); err != nil || filepath.ToSlash(rel) != want {
+			t.Errorf("syncPath(%q) = %s, want %s below the directory", name, local, want)
+		}
+	}
+
+	invalid := []string{
+		"", "/", ".", "..", "../..",
+		"link/secret", "link/new/file", "/link/../link/secret",
+		"build/" + TEMP_FILE_PREFIX + "x" + TEMP_FILE_SUFFIX,
+		TEMP_FILE_PREFIX + "x" + TEMP_FILE_SUFFIX + "/file",
+	}
+	for _, name := range invalid {
+		if local, err := storage.syncPath(name); err == nil {
+			t.Errorf("syncPath(%q) = %s, want an error", name, local)
+		}
+	}
+}
+
+func TestCreateAtStaysInside(t *testing.T) {
+	storage, outside := syncStorage(t)
+
+	for _, name := range []string{"link/secret", "link/new", "", "/"} {
+		if _, err := storage.CreateAt(name); err == nil {
+			t.Errorf("CreateAt(%q) succeeded", name)
+		}
+	}
+
+	// Replacing a link replaces the link, not what it points to
+	storeAt(t, storage, "escape", "replaced")
+	if content, err := os.ReadFile(filepath.Join(outside, "secret")); err != nil || string(content) != "secret" {
+		t.Errorf("file outside changed to %q (%v)", content, err)
+	}
+	if info, err := os.Lstat(filepath.Join(storage.Dir(), "escape")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("link was not replaced by a file (%v)", err)
+	}
+
+	storeAt(t, storage, "../../build/bin/app", "app")
+	if content, err := os.ReadFile(filepath.Join(storage.Dir(), "build", "bin", "app")); err != nil || string(content) != "app" {
+		t.Errorf("file stored as %q (%v), want it below the directory", content, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(storage.Dir())); len(entries) != 2 {
+		t.Errorf("files appeared next to the directory: %v", entries)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	storage, outside := syncStorage(t)
+	storeAt(t, storage, "build/bin/app", "app")
+	storeAt(t, storage, "build/readme", "readme")
+
+	refused := []string{
+		"../outside/secret",
+		"build/../../outside/secret",
+		filepath.Join(outside, "secret"),
+		"link/secret",
+		"escape", // A link, not a file
+		"build",
+		"inlink",
+		"build/missing",
+		"",
+		"/",
+	}
+	for _, name := range refused {
+		if err := storage.Remove(name); err == nil {
+			t.Errorf("Remove(%q) succeeded", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Fatalf("file outside was removed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(storage.Dir(), "escape")); err != nil {
+		t.Errorf("link was removed: %v", err)
+	}
+
+	// Directories left empty go away, up to but not including the directory itself
+	if err := storage.Remove("build/bin/app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(storage.Dir(), "build", "bin")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("empty directory kept (%v)", err)
+	}
+	if err := storage.Remove("/build/readme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(storage.Dir(), "build")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("empty directory kept (%v)", err)
+	}
+	if _, err := os.Stat(storage.Dir()); err != nil {
+		t.Errorf("upload directory removed: %v", err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	storage, _ := syncStorage(t)
+	storeAt(t, storage, "site/index.html", "index")
+	storeAt(t, storage, "site/css/main.css", "css")
+	// Links and files of transfers in progress are left out
+	if err := os.Symlink("index.html", filepath.Join(storage.Dir(), "site", "home.html")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.CreateAt("site/partial"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := storage.Manifest("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"css/main.css", "index.html"}) {
+		t.Errorf("manifest names = %q", names)
+	}
+	if entries[1].Size != 5 || entries[1].SHA256 != "1bc04b5291c26a46d918139138b992d2de976d6851d0893b0476b85bfbdfc6e6" {
+		t.Errorf("manifest entry = %+v", entries[1])
+	}
+
+	if entries, err := storage.Manifest("missing"); err != nil || !reflect.DeepEqual(entries, []protocol.Entry{}) {
+		t.Errorf("Manifest of a missing target = %v, %v, want no files", entries, err)
+	}
+	// Cleaned to a target below the directory that does not exist
+	if entries, err := storage.Manifest("../outside"); err != nil || len(entries) != 0 {
+		t.Errorf("Manifest(../outside) = %v, %v, want no files", entries, err)
+	}
+	for _, target := range []string{"link", "link/sub", "escape", "site/index.html", ""} {
+		if entries, err := storage.Manifest(target); err == nil {
+			t.Errorf("Manifest(%q) = %v, want an error", target, entries)
+		}
+	}
+}
@@ -0,0 +1,833 @@
+package sender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/progress"
+	"local-share/pkg/protocol"
+	"local-share/pkg/ratelimit"
+)
+
+const (
+	DEFAULT_DIAL_TIMEOUT = 10 * time.Second
+	RETRY_DELAY          = 500 * time.Millisecond // Wait before the first retry, doubled for every further one
+	MAX_RETRY_DELAY      = 5 * time.Second
+	DEFAULT_TIMEOUT      = 30 * time.Second
+	MAX_REPLY_LINE       = 64 * 1024
+	BUFFER_SIZE          = 1024 * 1024 // 1MB buffer for file transfers
+)
+
+// Dialer opens connections to receivers. *net.Dialer satisfies it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Result describes a completed transfer
+type Result struct {
+	Addr       string        // Receiver address
+	Name       string        // Name of the sent file, empty for text
+	StoredName string        // Name the receiver stored the file under (the local path for Get), empty for text
+	Bytes      int64         // Plaintext bytes sent
+	Checksum   string        // SHA-256 of the content, hex encoded, confirmed by the receiver
+	Streams    int           // Connections a file was sent over, 1 unless sent in parallel, 0 for text and Get
+	Duration   time.Duration // Time from connecting to the receiver's confirmation
+}
+
+// Client sends encrypted text and files to receivers
+type Client struct {
+	key      string
+	dialer   Dialer
+	timeout  time.Duration
+	device   string
+	progress progress.Factory
+	logger   *slog.Logger
+	streams  int
+	limiter  *ratelimit.Limiter
+	retries  int
+	wait     time.Duration
+
+	agreements sync.Map // protocol.Agreement by receiver address
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithKey sets the (padded) encryption key shared with the receiver
+func WithKey(key string) Option {
+	return func(c *Client) {
+		c.key = key
+	}
+}
+
+// WithDialer sets how connections are opened (default: net.Dialer with a 10s timeout)
+func WithDialer(dialer Dialer) Option {
+	return func(c *Client) {
+		c.dialer = dialer
+	}
+}
+
+// WithDialTimeout sets how long connecting to the receiver may take (default 10s).
+// It replaces a dialer set with WithDialer.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.dialer = &net.Dialer{Timeout: timeout}
+	}
+}
+
+// WithRetries connects again up to n times when the receiver cannot be reached (default 0).
+// The delay between attempts grows exponentially from RETRY_DELAY to MAX_RETRY_DELAY, with jitter.
+// Only connecting is retried, never a transfer the receiver has seen.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// WithWait keeps trying to connect for up to timeout, for receivers that are still starting,
+// in addition to any retries (default 0: do not wait)
+func WithWait(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.wait = timeout
+	}
+}
+
+// WithTimeout sets how long a read or write may stall before the transfer fails (default 30s).
+// Waiting for the receiver to approve a file is not limited; use the context for that.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithDeviceName sets the name shown to the receiver (default: the hostname)
+func WithDeviceName(name string) Option {
+	return func(c *Client) {
+		c.device = name
+	}
+}
+
+// WithProgress reports the progress of file transfers to reporters created by factory
+func WithProgress(factory progress.Factory) Option {
+	return func(c *Client) {
+		c.progress = factory
+	}
+}
+
+// WithStreams sends files of at least MIN_PARALLEL_SIZE over up to n connections at once (default 1).
+// The receiver may allow fewer; receivers without parallel transfers get the file over one connection.
+func WithStreams(n int) Option {
+	return func(c *Client) {
+		c.streams = n
+	}
+}
+
+// WithRateLimit limits all connections of the client together to bytesPerSecond (default: no limit)
+func WithRateLimit(bytesPerSecond int64) Option {
+	return func(c *Client) {
+		c.limiter = ratelimit.NewLimiter(bytesPerSecond)
+	}
+}
+
+// withLimiter shares limiter with other clients
+func withLimiter(limiter *ratelimit.Limiter) Option {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
+
+// WithLogger sets the structured logger for diagnostics (default: discard).
+// Every step of a transfer is logged at debug level with the "remote" address,
+// connection retries at info level.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// NewClient creates a client configured by options
+func NewClient(options ...Option) *Client {
+	device, err := os.Hostname()
+	if err != nil {
+		device = "unknown"
+	}
+
+	c := &Client{
+		dialer:  &net.Dialer{Timeout: DEFAULT_DIAL_TIMEOUT},
+		timeout: DEFAULT_TIMEOUT,
+		device:  device,
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// SendText sends an encrypted text message to the receiver at addr ("host" or "host:port")
+func (c *Client) SendText(ctx context.Context, addr, message string) (*Result, error) {
+	started := time.Now()
+	addr = withDefaultPort(addr)
+	log := c.logger.With("remote", addr)
+	fail := func(kind, err error) (*Result, error) {
+		return nil, c.wrapError(ctx, log, "send text", addr, kind, err)
+	}
+
+	if c.key == "" {
+		return fail(ErrNoKey, nil)
+	}
+
+	// Encrypt the message
+	encryptedMsg, err := crypto.Encrypt([]byte(message), []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	s, err := c.open(ctx, log, addr)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	defer s.close()
+
+	// Send the encrypted message with "TEXT:" prefix
+	if err := s.writeLine(protocol.TEXT_PREFIX + encryptedMsg); err != nil {
+		return fail(ErrConnect, err)
+	}
+
+	_, checksum, err := s.readDone(c.key)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+
+	sum := sha256.Sum256([]byte(message))
+	if checksum != hex.EncodeToString(sum[:]) {
+		return fail(ErrChecksum, nil)
+	}
+
+	duration := time.Since(started)
+	log.Debug("text sent", "bytes", len(message), "duration", duration, "sha256", checksum)
+	return &Result{
+		Addr:     addr,
+		Bytes:    int64(len(message)),
+		Checksum: checksum,
+		Duration: duration,
+	}, nil
+}
+
+// SendFile sends an encrypted file to the receiver at addr ("host" or "host:port")
+func (c *Client) SendFile(ctx context.Context, addr, filePath string) (*Result, error) {
+	return c.sendFile(ctx, "send file", protocol.FILE_PREFIX, addr, filePath, filepath.Base(filePath))
+}
+
+// sendFile sends the file at filePath under name, announced with prefix
+func (c *Client) sendFile(ctx context.Context, op, prefix, addr, filePath, name string) (*Result, error) {
+	started := time.Now()
+	addr = withDefaultPort(addr)
+	log := c.logger.With("remote", addr, "file", name)
+	fail := func(kind, err error) (*Result, error) {
+		return nil, c.wrapError(ctx, log, op, addr, kind, err)
+	}
+
+	if c.key == "" {
+		return fail(ErrNoKey, nil)
+	}
+
+	// Open the file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fail(ErrLocalIO, fmt.Errorf("%s is not a regular file", filePath))
+	}
+	size := info.Size()
+
+	// Large files may be split over several connections
+	if prefix == protocol.FILE_PREFIX && c.streams > 1 && size >= MIN_PARALLEL_SIZE && c.mayParallel(addr) {
+		result, err := c.sendParallel(ctx, log, addr, file, size, name, started)
+		if !errors.Is(err, errParallelUnsupported) {
+			return result, err
+		}
+		log.Debug("sending over one connection", "reason", err)
+	}
+
+	// Encrypt the filename
+	encryptedFilename, err := crypto.Encrypt([]byte(name), []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	s, err := c.open(ctx, log, addr)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	defer s.close()
+
+	// Send the encrypted filename followed by the encrypted content length
+	if err := s.writeLine(prefix + encryptedFilename); err != nil {
+		return fail(ErrConnect, err)
+	}
+	if err := s.writeLine(fmt.Sprintf("%d", crypto.EncryptedSize(size))); err != nil {
+		return fail(ErrConnect, err)
+	}
+
+	// Wait for the receiver to accept the transfer
+	log.Debug("waiting for approval", "bytes", size)
+	if err := s.readAccept(); err != nil {
+		return fail(classify(err), err)
+	}
+	log.Debug("transfer accepted")
+
+	// Encrypt the content while it is sent, hashing it for the receiver's confirmation
+	hash := sha256.New()
+	source := &fileReader{r: io.TeeReader(io.LimitReader(file, size), hash)}
+	var content io.Reader = source
+	if c.progress != nil {
+		reporter := c.progress(name, size)
+		defer reporter.Finish()
+		content = &progress.Reader{R: content, Reporter: reporter}
+	}
+
+	encrypter, err := crypto.NewEncryptWriter(s.writer, []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	written, err := io.CopyBuffer(encrypter, content, make([]byte, BUFFER_SIZE))
+	if source.err != nil {
+		return fail(ErrLocalIO, source.err)
+	}
+	if err != nil {
+		return fail(ErrConnect, err)
+	}
+	if written != size {
+		return fail(ErrLocalIO, fmt.Errorf("%s changed size while it was sent", filePath))
+	}
+	if err := encrypter.Close(); err != nil {
+		return fail(ErrConnect, err)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fail(ErrConnect, err)
+	}
+	sum := hash.Sum(nil)
+
+	storedName, checksum, err := s.readDone(c.key)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	if checksum != hex.EncodeToString(sum) {
+		return fail(ErrChecksum, fmt.Errorf("receiver stored %s", checksum))
+	}
+
+	duration := time.Since(started)
+	log.Debug("file sent", "stored_as", storedName, "bytes", written, "duration", duration, "sha256", checksum)
+	return &Result{
+		Addr:       addr,
+		Name:       name,
+		StoredName: storedName,
+		Bytes:      written,
+		Checksum:   checksum,
+		Streams:    1,
+		Duration:   duration,
+	}, nil
+}
+
+// List returns the entries of the directory dir ("" for the top) shared by the receiver at addr
+func (c *Client) List(ctx context.Context, addr, dir string) ([]protocol.Entry, error) {
+	return c.entries(ctx, "list", protocol.LIST_PREFIX, addr, dir)
+}
+
+// Manifest returns the files below the sync target on the receiver at addr with their checksums
+func (c *Client) Manifest(ctx context.Context, addr, target string) ([]protocol.Entry, error) {
+	return c.entries(ctx, "sync", protocol.MANIFEST_PREFIX, addr, target)
+}
+
+// entries sends a request for entries, announced with prefix, and reads the JSON reply
+func (c *Client) entries(ctx context.Context, op, prefix, addr, dir string) ([]protocol.Entry, error) {
+	addr = withDefaultPort(addr)
+	log := c.logger.With("remote", addr, "dir", dir)
+	fail := func(kind, err error) ([]protocol.Entry, error) {
+		return nil, c.wrapError(ctx, log, op, addr, kind, err)
+	}
+
+	if c.key == "" {
+		return fail(ErrNoKey, nil)
+	}
+	encryptedDir, err := crypto.Encrypt([]byte(dir), []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	s, err := c.open(ctx, log, addr)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	defer s.close()
+	if err := s.writeLine(prefix + encryptedDir); err != nil {
+		return fail(ErrConnect, err)
+	}
+
+	var listing bytes.Buffer
+	checksum, err := s.readPayload(c.key, &listing, nil)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	if sum := sha256.Sum256(listing.Bytes()); checksum != hex.EncodeToString(sum[:]) {
+		return fail(ErrChecksum, nil)
+	}
+	var entries []protocol.Entry
+	if err := json.Unmarshal(listing.Bytes(), &entries); err != nil {
+		return fail(ErrProtocol, err)
+	}
+	log.Debug("listing received", "entries", len(entries))
+	return entries, nil
+}
+
+// SyncFile sends the file at filePath to the receiver at addr, replacing the file at the sync path name
+func (c *Client) SyncFile(ctx context.Context, addr, filePath, name string) (*Result, error) {
+	return c.sendFile(ctx, "sync", protocol.SYNC_PREFIX, addr, filePath, name)
+}
+
+// Delete removes the file at the sync path name from the receiver at addr
+func (c *Client) Delete(ctx context.Context, addr, name string) error {
+	addr = withDefaultPort(addr)
+	log := c.logger.With("remote", addr, "file", name)
+	fail := func(kind, err error) error {
+		return c.wrapError(ctx, log, "sync", addr, kind, err)
+	}
+
+	if c.key == "" {
+		return fail(ErrNoKey, nil)
+	}
+	encryptedName, err := crypto.Encrypt([]byte(name), []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	s, err := c.open(ctx, log, addr)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	defer s.close()
+	if err := s.writeLine(protocol.DELETE_PREFIX + encryptedName); err != nil {
+		return fail(ErrConnect, err)
+	}
+
+	// The receiver may ask before deleting, like before receiving
+	s.conn.waiting = true
+	_, _, err = s.readDone(c.key)
+	s.conn.waiting = false
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	log.Debug("file deleted")
+	return nil
+}
+
+// Get downloads the file name shared by the receiver at addr and stores it at destPath,
+// replacing an existing file. The file only appears once it is complete and verified.
+func (c *Client) Get(ctx context.Context, addr, name, destPath string) (*Result, error) {
+	started := time.Now()
+	addr = withDefaultPort(addr)
+	log := c.logger.With("remote", addr, "file", name)
+	fail := func(kind, err error) (*Result, error) {
+		return nil, c.wrapError(ctx, log, "get", addr, kind, err)
+	}
+
+	if c.key == "" {
+		return fail(ErrNoKey, nil)
+	}
+	encryptedName, err := crypto.Encrypt([]byte(name), []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	// Receive into a temporary file next to the destination
+	temp, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+"-*.part")
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	s, err := c.open(ctx, log, addr)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	defer s.close()
+	if err := s.writeLine(protocol.GET_PREFIX + encryptedName); err != nil {
+		return fail(ErrConnect, err)
+	}
+
+	hash := sha256.New()
+	target := &fileWriter{w: temp}
+	var report func(size int64) progress.Reporter
+	if c.progress != nil {
+		report = func(size int64) progress.Reporter {
+			return c.progress(path.Base(name), size)
+		}
+	}
+	checksum, err := s.readPayload(c.key, io.MultiWriter(target, hash), report)
+	if target.err != nil {
+		return fail(ErrLocalIO, target.err)
+	}
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); checksum != sum {
+		return fail(ErrChecksum, fmt.Errorf("received %s", sum))
+	}
+
+	info, err := temp.Stat()
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	if err := os.Rename(temp.Name(), destPath); err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	duration := time.Since(started)
+	log.Debug("file received", "path", destPath, "bytes", info.Size(), "duration", duration, "sha256", checksum)
+	return &Result{
+		Addr:       addr,
+		Name:       name,
+		StoredName: destPath,
+		Bytes:      info.Size(),
+		Checksum:   checksum,
+		Duration:   duration,
+	}, nil
+}
+
+// fileWriter remembers write errors so they can be told apart from network errors
+type fileWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (f *fileWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		f.err = err
+	}
+	return n, err
+}
+
+// fileReader remembers read errors so they can be told apart from network errors
+type fileReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err != nil && err != io.EOF {
+		f.err = err
+	}
+	return n, err
+}
+
+// wrapError builds an *Error, reporting cancellation instead of the resulting network error
+func (c *Client) wrapError(ctx context.Context, log *slog.Logger, op, addr string, kind, err error) error {
+	if ctx.Err() != nil {
+		kind, err = ctx.Err(), nil
+	}
+	wrapped := &Error{Op: op, Addr: addr, Kind: kind, Err: err}
+	log.Debug("transfer failed", "error", wrapped)
+	return wrapped
+}
+
+// session is one connection to a receiver
+type session struct {
+	conn   *timeoutConn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	stop   func() bool
+}
+
+// open connects to the receiver and identifies this device
+func (c *Client) open(ctx context.Context, log *slog.Logger, addr string) (*session, error) {
+	s, err := c.connect(ctx, log, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Agree on the protocol
+	if err := c.hello(s, log, addr); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	// Identify this device to the receiver
+	encryptedDevice, err := crypto.Encrypt([]byte(protocol.DEVICE_MAGIC+c.device), []byte(c.key))
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	if err := s.writeLine(protocol.FROM_PREFIX + encryptedDevice); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect opens a connection to the receiver
+func (c *Client) connect(ctx context.Context, log *slog.Logger, addr string) (*session, error) {
+	conn, err := c.dial(ctx, log, addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("connected", "local", conn.LocalAddr().String())
+
+	// The rate limit applies outside the timeouts, so waiting for it does not count as a stall
+	tc := &timeoutConn{Conn: conn, timeout: c.timeout}
+	limited := ratelimit.NewConn(tc, c.limiter)
+	return &session{
+		conn:   tc,
+		reader: bufio.NewReader(limited),
+		writer: bufio.NewWriter(limited),
+		// Abort the transfer when the context ends
+		stop: context.AfterFunc(ctx, func() { conn.Close() }),
+	}, nil
+}
+
+// hello tells the receiver what this client supports and checks what it chose
+func (c *Client) hello(s *session, log *slog.Logger, addr string) error {
+	hello := protocol.NewHello(crypto.CIPHER, protocol.FEATURE_TEXT, protocol.FEATURE_FILE,
+		protocol.FEATURE_PARALLEL, protocol.FEATURE_SYNC, protocol.FEATURE_SHARE)
+	line, err := protocol.HelloLine(hello)
+	if err != nil {
+		return err
+	}
+	if err := s.writeLine(line); err != nil {
+		return err
+	}
+
+	reply, err := protocol.ReadLine(s.reader, MAX_REPLY_LINE)
+	if errors.Is(err, io.EOF) {
+		// Version 0 receivers close the connection on lines they do not know
+		return &protocol.IncompatibleError{Reason: "receiver closed the connection without answering HELLO " +
+			"(it predates the hello exchange and must be updated, or its access rules refuse this address)"}
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(reply, protocol.HELLO_PREFIX) {
+		// The receiver refused the connection, e.g. because it is busy
+		err := protocol.ParseReply(reply)
+		if err == nil {
+			err = fmt.Errorf("%w: ACCEPT instead of HELLO", protocol.ErrUnexpectedReply)
+		}
+		return err
+	}
+
+	var agreement protocol.Agreement
+	if err := protocol.ParseHello(reply, &agreement); err != nil {
+		return err
+	}
+	if err := hello.Check(agreement); err != nil {
+		return err
+	}
+	c.agreements.Store(addr, agreement)
+	log.Debug("protocol negotiated", "version", agreement.Version, "cipher", agreement.Cipher,
+		"compression", agreement.Compression, "features", agreement.Features)
+	return nil
+}
+
+// dial connects to the receiver, retrying as configured with WithRetries and WithWait
+func (c *Client) dial(ctx context.Context, log *slog.Logger, addr string) (net.Conn, error) {
+	deadline := time.Now().Add(c.wait)
+	for attempt := 0; ; attempt++ {
+		log.Debug("connecting", "attempt", attempt+1)
+		conn, err := c.dialer.DialContext(ctx, "tcp", addr)
+		if err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+
+		delay := retryDelay(attempt)
+		if attempt >= c.retries && time.Now().Add(delay).After(deadline) {
+			if attempt > 0 {
+				err = fmt.Errorf("%w (gave up after %d attempts)", err, attempt+1)
+			}
+			return nil, err
+		}
+		log.Info("receiver not reachable, retrying", "error", err, "attempt", attempt+1, "delay", delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns the wait before retry number attempt+1: exponential backoff with
+// jitter, so senders started together do not all retry at the same moment
+func retryDelay(attempt int) time.Duration {
+	delay := MAX_RETRY_DELAY
+	if attempt < 10 {
+		delay = min(RETRY_DELAY<<attempt, MAX_RETRY_DELAY)
+	}
+	// Between half and all of the delay
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (s *session) close() {
+	s.stop()
+	s.conn.Close()
+}
+
+// writeLine sends one protocol line
+func (s *session) writeLine(line string) error {
+	if _, err := s.writer.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+// readAccept waits, without a timeout, for the receiver to accept or reject the transfer
+func (s *session) readAccept() error {
+	s.conn.waiting = true
+	line, err := protocol.ReadLine(s.reader, MAX_REPLY_LINE)
+	s.conn.waiting = false
+	if err != nil {
+		return err
+	}
+	return protocol.ParseReply(line)
+}
+
+// readPayload reads the reply to a LIST, GET or MANIFEST request: ACCEPT, the payload length, the encrypted
+// payload, which is decrypted into w, and DONE. It returns the checksum the receiver sent.
+// If report is set it is called with the content size and gets the progress of the content.
+func (s *session) readPayload(key string, w io.Writer, report func(size int64) progress.Reporter) (string, error) {
+	if err := s.readAccept(); err != nil {
+		return "", err
+	}
+	lengthLine, err := protocol.ReadLine(s.reader, MAX_REPLY_LINE)
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseInt(lengthLine, 10, 64)
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("%w: invalid content length %q", protocol.ErrUnexpectedReply, lengthLine)
+	}
+
+	content := &io.LimitedReader{R: s.reader, N: length}
+	decrypted, err := crypto.NewDecryptReader(content, []byte(key))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", protocol.ErrUnexpectedReply, err)
+	}
+	if report != nil {
+		reporter := report(crypto.PlaintextSize(length))
+		defer reporter.Finish()
+		w = &progress.Writer{W: w, Reporter: reporter}
+	}
+	if _, err := io.CopyBuffer(w, decrypted, make([]byte, BUFFER_SIZE)); err != nil {
+		return "", err
+	}
+	if content.N > 0 {
+		return "", fmt.Errorf("connection closed with %d bytes missing", content.N)
+	}
+
+	_, checksum, err := s.readDone(key)
+	return checksum, err
+}
+
+// readDone reads the receiver's confirmation and returns the stored name and checksum
+func (s *session) readDone(key string) (string, string, error) {
+	line, err := protocol.ReadLine(s.reader, MAX_REPLY_LINE)
+	if err != nil {
+		return "", "", err
+	}
+
+	encryptedResult, err := protocol.ParseDone(line)
+	if err != nil {
+		return "", "", err
+	}
+
+	result, err := crypto.Decrypt(encryptedResult, []byte(key))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", protocol.ErrUnexpectedReply, err)
+	}
+	storedName, checksum, found := strings.Cut(result, "\n")
+	if !found {
+		return "", "", fmt.Errorf("%w: malformed result", protocol.ErrUnexpectedReply)
+	}
+	return storedName, checksum, nil
+}
+
+// classify maps a failure while talking to the receiver to an error kind
+func classify(err error) error {
+	var reject *protocol.RejectError
+	var incompatible *protocol.IncompatibleError
+	switch {
+	case errors.As(err, &incompatible), errors.As(err, &reject) && reject.Code == protocol.REJECT_INCOMPATIBLE:
+		return ErrIncompatible
+	case errors.As(err, &reject) && reject.Code == protocol.REJECT_AUTH:
+		return ErrAuth
+	case errors.As(err, &reject):
+		return ErrRejected
+	case errors.Is(err, protocol.ErrUnexpectedReply), errors.Is(err, protocol.ErrLineTooLong):
+		return ErrProtocol
+	default:
+		return ErrConnect
+	}
+}
+
+// timeoutConn refreshes the deadlines before every read and write.
+// While waiting for the receiver to approve a transfer reads have no deadline.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+	waiting bool
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	if c.timeout > 0 && !c.waiting {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	} else {
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Write(p)
+}
+
+// withDefaultPort appends the default port when addr has none
+func withDefaultPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), strings.TrimPrefix(PORT, ":"))
+}
@@ -0,0 +1,172 @@
+package sender
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"local-share/pkg/protocol"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+)
+
+// scriptedReceiver answers the first line of every connection with reply, closing the
+// connection without an answer when reply returns "". It returns its address and the
+// first lines it was sent.
+func scriptedReceiver(t *testing.T, reply func(line string) string) (string, func() []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	var lines []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			line = strings.TrimSpace(line)
+			mu.Lock()
+			lines = append(lines, line)
+			mu.Unlock()
+			if answer := reply(line); answer != "" {
+				conn.Write([]byte(answer + "\n"))
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return lines
+	}
+}
+
+func TestHelloReplies(t *testing.T) {
+	tests := []struct {
+		name     string
+		answer   string // Reply to HELLO, "" to close the connection
+		wantKind error
+	}{
+		{"version 0 closes the connection", "", ErrIncompatible},
+		{"hello rejected", protocol.RejectLine(protocol.REJECT_INVALID, "unknown transfer type"), ErrRejected},
+		{"hello rejected without a code", "REJECT:unknown transfer type", ErrRejected},
+		{"busy receiver", protocol.RejectLine(protocol.REJECT_LIMIT, "receiver busy"), ErrRejected},
+		{"incompatible receiver", protocol.RejectLine(protocol.REJECT_INCOMPATIBLE, "no common cipher"), ErrIncompatible},
+		{"ACCEPT instead of HELLO", "ACCEPT", ErrProtocol},
+		{"invalid agreement", `HELLO:{"version":1,"cipher":"aes-256-cfb","compression":"none"}`, ErrIncompatible},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, lines := scriptedReceiver(t, func(string) string { return strings.TrimSpace(test.answer) })
+			client := NewClient(WithKey(testKey))
+			_, err := client.SendText(context.Background(), addr, "hello")
+			if !errors.Is(err, test.wantKind) {
+				t.Fatalf("SendText = %v, want %v", err, test.wantKind)
+			}
+			// The sender never tries again without the hello exchange
+			if got := lines(); len(got) != 1 || !strings.HasPrefix(got[0], protocol.HELLO_PREFIX) {
+				t.Errorf("receiver got connections starting with %q, want one with HELLO", got)
+			}
+		})
+	}
+}
+
+func TestVersion0ReceiverIsReported(t *testing.T) {
+	addr, _ := scriptedReceiver(t, func(string) string { return "" })
+	client := NewClient(WithKey(testKey))
+	_, err := client.SendText(context.Background(), addr, "hello")
+	if !errors.Is(err, ErrIncompatible) || !strings.Contains(err.Error(), "without answering HELLO") {
+		t.Errorf("SendText = %v, want an incompatible peer closing without answering HELLO", err)
+	}
+}
+
+func TestHelloAgreement(t *testing.T) {
+	addr := receivertest.Start(t, testKey, receiver.WithLimits(receiver.Limits{MaxStreams: 1})).Addr
+	client := NewClient(WithKey(testKey))
+	if _, err := client.SendText(context.Background(), addr, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	value, ok := client.agreements.Load(addr)
+	if !ok {
+		t.Fatal("no agreement stored")
+	}
+	agreement := value.(protocol.Agreement)
+	if agreement.Version != protocol.VERSION || !agreement.Supports(protocol.FEATURE_TEXT) || agreement.Supports(protocol.FEATURE_PARALLEL) {
+		t.Errorf("agreement = %+v, want version %d with text and without parallel", agreement, protocol.VERSION)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := range 12 {
+		want := min(RETRY_DELAY<<attempt, MAX_RETRY_DELAY)
+		seen := make(map[time.Duration]bool)
+		for range 100 {
+			delay := retryDelay(attempt)
+			if delay < want/2 || delay > want {
+				t.Fatalf("retryDelay(%d) = %v, want between %v and %v", attempt, delay, want/2, want)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("retryDelay(%d) always returned %v, want jitter", attempt, want)
+		}
+	}
+	// Large attempts must not overflow the shift
+	if delay := retryDelay(100); delay < MAX_RETRY_DELAY/2 || delay > MAX_RETRY_DELAY {
+		t.Errorf("retryDelay(100) = %v, want at most %v", delay, MAX_RETRY_DELAY)
+	}
+}
+
+func TestOnlyConnectingIsRetried(t *testing.T) {
+	// A receiver that starts listening after the first attempt was refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	var texts atomic.Int32
+	server := receiver.NewServer(receiver.WithKey(testKey), receiver.WithTextHandler(func(receiver.TextMessage) { texts.Add(1) }))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(RETRY_DELAY / 4)
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("listening again on %s: %v", addr, err)
+			return
+		}
+		server.Serve(ctx, listener)
+	}()
+
+	client := NewClient(WithKey(testKey), WithRetries(3))
+	if _, err := client.SendText(context.Background(), addr, "hello"); err != nil {
+		t.Fatalf("SendText = %v, want it to reach the receiver on a retry", err)
+	}
+	if n := texts.Load(); n != 1 {
+		t.Errorf("receiver got %d texts, want 1", n)
+	}
+
+	// A receiver that refuses the transfer is not asked again
+	rejecting, lines := scriptedReceiver(t, func(string) string {
+		return strings.TrimSpace(protocol.RejectLine(protocol.REJECT_LIMIT, "receiver busy"))
+	})
+	if _, err := client.SendText(context.Background(), rejecting, "hello"); !errors.Is(err, ErrRejected) {
+		t.Fatalf("SendText = %v, want %v", err, ErrRejected)
+	}
+	if n := len(lines()); n != 1 {
+		t.Errorf("rejecting receiver got %d connections, want 1", n)
+	}
+}
@@ -0,0 +1,53 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+
+	"local-share/pkg/protocol"
+)
+
+// Error kinds returned by the Client. Test for them with errors.Is.
+var (
+	ErrNoKey        = errors.New("no encryption key configured")
+	ErrConnect      = errors.New("cannot connect to receiver")
+	ErrAuth         = errors.New("authentication failed")
+	ErrRejected     = errors.New("transfer rejected")
+	ErrProtocol     = errors.New("protocol error")
+	ErrChecksum     = errors.New("checksum mismatch")
+	ErrLocalIO      = errors.New("local I/O error")
+	ErrIncompatible = errors.New("incompatible peer")
+)
+
+// Error describes a failed operation. It matches its Kind with errors.Is and exposes
+// the underlying cause (for example a *protocol.RejectError) through errors.As.
+type Error struct {
+	Op   string // "send text", "send file", "list", "get", "sync" or "watch"
+	Addr string // Receiver address
+	Kind error  // One of the Err* values
+	Err  error  // Underlying cause
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s %s: %v", e.Op, e.Addr, e.Kind)
+	}
+
+	// The receiver's reason already says it was rejected
+	var reject *protocol.RejectError
+	if errors.As(e.Err, &reject) {
+		return fmt.Sprintf("%s %s: %v: %s", e.Op, e.Addr, e.Kind, reject.Reason)
+	}
+	var incompatible *protocol.IncompatibleError
+	if errors.As(e.Err, &incompatible) {
+		return fmt.Sprintf("%s %s: %v: %s", e.Op, e.Addr, e.Kind, incompatible.Reason)
+	}
+	return fmt.Sprintf("%s %s: %v: %v", e.Op, e.Addr, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
@@ -0,0 +1,174 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"local-share/pkg/output"
+	"local-share/pkg/progress"
+	"local-share/pkg/ratelimit"
+)
+
+const (
+	MAX_PARALLEL_SENDS = 8 // Receivers SendFileToAll sends to at the same time
+)
+
+// Target is one receiver of SendFileToAll
+type Target struct {
+	Name   string // Peer name or address as given on the command line
+	Addr   string // Receiver address
+	Config Config // Settings for this receiver, such as its key source and fingerprint
+}
+
+// targetResult is the outcome of sending to one target
+type targetResult struct {
+	result *Result
+	err    error
+}
+
+// SendFileToAll sends an encrypted file to several servers at once from the command line.
+// Each password is asked for only once, even if several targets use it, and a table of the
+// results is printed at the end. If any transfer failed the error of the first failed target
+// is returned; it matches one of the Err* kinds with errors.Is.
+func SendFileToAll(targets []Target, filePath string) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no receivers to send to")
+	}
+	out := targets[0].Config.printer()
+	if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+		names := make([]string, len(targets))
+		for i, target := range targets {
+			names[i] = target.Name
+		}
+		err := &Error{Op: "send file", Addr: strings.Join(names, ","), Kind: ErrLocalIO, Err: fmt.Errorf("%s is not a regular file", filePath)}
+		printError(out, err)
+		return err
+	}
+
+	// Get the keys up front, so no prompt interrupts the transfers
+	type keySource struct {
+		source   string
+		insecure bool
+	}
+	keys := make(map[keySource]string)
+	results := make([]targetResult, len(targets))
+	targetKeys := make([]string, len(targets))
+	for i, target := range targets {
+		source := keySource{target.Config.KeySource, target.Config.InsecureNoPassword}
+		key, ok := keys[source]
+		if !ok {
+			var err error
+			key, err = getKey(out, source.source, source.insecure)
+			if err != nil {
+				out.Error("Error getting encryption key", err)
+				return fmt.Errorf("%w: %w", ErrNoKey, err)
+			}
+			keys[source] = key
+		}
+		targetKeys[i] = key
+		// A wrong fingerprint only fails this target
+		results[i].err = target.Config.checkFingerprint(key)
+	}
+
+	out.Event("sending", output.Fields{
+		"name":    filepath.Base(filePath),
+		"targets": len(targets),
+	}, "Sending %s to %d receivers (waiting for them to accept)...\n", filepath.Base(filePath), len(targets))
+
+	// --limit applies to all transfers together
+	limiter := ratelimit.NewLimiter(targets[0].Config.RateLimit)
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, MAX_PARALLEL_SENDS)
+	for i, target := range targets {
+		if results[i].err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			// No progress bars: several of them would garble the terminal
+			client := NewClient(append(target.Config.options(targetKeys[i]), withLimiter(limiter))...)
+			results[i].result, results[i].err = client.SendFile(context.Background(), target.Config.address(target.Addr), filePath)
+		}()
+	}
+	wg.Wait()
+
+	var firstErr error
+	sent := 0
+	for i, target := range targets {
+		if err := results[i].err; err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if out.JSON() {
+				fields := errorFields(err)
+				fields["target"] = target.Name
+				out.Event("error", fields, "")
+			}
+			continue
+		}
+		sent++
+		if out.JSON() {
+			result := results[i].result
+			out.Event("sent", output.Fields{
+				"type":        "file",
+				"target":      target.Name,
+				"addr":        result.Addr,
+				"name":        result.Name,
+				"stored_as":   result.StoredName,
+				"bytes":       result.Bytes,
+				"sha256":      result.Checksum,
+				"streams":     result.Streams,
+				"duration_ms": result.Duration.Milliseconds(),
+			}, "")
+		}
+	}
+	if !out.JSON() {
+		printResultTable(out, targets, results)
+	}
+	out.Event("summary", output.Fields{
+		"name":    filepath.Base(filePath),
+		"targets": len(targets),
+		"sent":    sent,
+		"failed":  len(targets) - sent,
+	}, "Sent %s to %d of %d receivers\n", filepath.Base(filePath), sent, len(targets))
+	return firstErr
+}
+
+// printResultTable prints one line per target with the outcome of its transfer.
+// In JSON mode the sent and error events of the targets take its place.
+func printResultTable(out *output.Printer, targets []Target, results []targetResult) {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tADDRESS\tRESULT\tSIZE\tTIME\tDETAILS")
+	for i, target := range targets {
+		addr := withDefaultPort(target.Config.address(target.Addr))
+		if err := results[i].err; err != nil {
+			fmt.Fprintf(w, "%s\t%s\tfailed\t-\t-\t%s\n", target.Name, addr, failureReason(err))
+			continue
+		}
+		result := results[i].result
+		fmt.Fprintf(w, "%s\t%s\tsent\t%s\t%s\tstored as %s\n", target.Name, addr, progress.FormatSize(result.Bytes),
+			result.Duration.Round(time.Millisecond), result.StoredName)
+	}
+	w.Flush()
+	out.Event("results", nil, "%s", table.String())
+}
+
+// failureReason describes err without the operation and address the table already shows
+func failureReason(err error) string {
+	if e, ok := err.(*Error); ok {
+		return strings.TrimPrefix(e.Error(), e.Op+" "+e.Addr+": ")
+	}
+	return err.Error()
+}
@@ -0,0 +1,90 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"local-share/pkg/output"
+	"local-share/pkg/protocol"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+)
+
+func TestSendFileToAllPartialFailure(t *testing.T) {
+	accepting := receivertest.Start(t, testKey)
+	declining := receivertest.Start(t, testKey, receiver.WithApprover(receiver.ApproverFunc(func(receiver.TransferRequest) bool { return false })))
+	file := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(file, []byte("quarterly numbers"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOCAL_SHARE_TEST_KEY", "Str0ng-Passw0rd!")
+	targets := func(out *output.Printer) []Target {
+		config := Config{KeySource: "env:LOCAL_SHARE_TEST_KEY", Output: out}
+		return []Target{
+			{Name: "desk", Addr: accepting.Addr, Config: config},
+			{Name: "lab", Addr: declining.Addr, Config: config},
+		}
+	}
+	checkErr := func(err error) {
+		t.Helper()
+		var reject *protocol.RejectError
+		if !errors.Is(err, ErrRejected) || !errors.As(err, &reject) || reject.Code != protocol.REJECT_DECLINED {
+			t.Errorf("SendFileToAll = %v, want the rejection by lab", err)
+		}
+	}
+
+	// JSON mode reports every target
+	var printed bytes.Buffer
+	checkErr(SendFileToAll(targets(output.New(&printed, true)), file))
+	events := make(map[string]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(printed.String()), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		key := event["event"].(string)
+		if target, ok := event["target"].(string); ok {
+			key += " " + target
+		}
+		events[key] = event
+	}
+	if sent := events["sent desk"]; sent == nil || sent["stored_as"] != "report.txt" {
+		t.Errorf("sent event for desk = %v", sent)
+	}
+	if failed := events["error lab"]; failed == nil || failed["kind"] != "rejected" || failed["reject_code"] != protocol.REJECT_DECLINED {
+		t.Errorf("error event for lab = %v", failed)
+	}
+	if events["sent lab"] != nil || events["error desk"] != nil {
+		t.Errorf("events for the wrong targets: %v", events)
+	}
+	if summary := events["summary"]; summary == nil || summary["sent"] != 1.0 || summary["failed"] != 1.0 {
+		t.Errorf("summary = %v, want 1 sent and 1 failed", summary)
+	}
+	if _, err := os.Stat(filepath.Join(accepting.Dir, "report.txt")); err != nil {
+		t.Errorf("desk did not store the file: %v", err)
+	}
+
+	// Humans get a table
+	printed.Reset()
+	checkErr(SendFileToAll(targets(output.New(&printed, false)), file))
+	rows := make(map[string]string)
+	for _, line := range strings.Split(printed.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 2 {
+			rows[fields[0]] = line
+		}
+	}
+	if row := rows["desk"]; !strings.Contains(row, " sent ") {
+		t.Errorf("row of desk = %q, want sent", row)
+	}
+	if row := rows["lab"]; !strings.Contains(row, " failed ") || !strings.Contains(row, "declined") {
+		t.Errorf("row of lab = %q, want failed as declined", row)
+	}
+	if !strings.Contains(printed.String(), "Sent report.txt to 1 of 2 receivers") {
+		t.Errorf("output %q has no summary", printed.String())
+	}
+}
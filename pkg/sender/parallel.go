@@ -0,0 +1,218 @@
+package sender
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/progress"
+	"local-share/pkg/protocol"
+)
+
+const (
+	MAX_STREAMS       = 32      // Most connections one file may be split over
+	MIN_PARALLEL_SIZE = 4 << 20 // 4MiB, smaller files are always sent over one connection
+)
+
+// errParallelUnsupported is returned by sendParallel when the receiver cannot take parallel transfers
+var errParallelUnsupported = errors.New("receiver does not support parallel transfers")
+
+// mayParallel reports whether a parallel transfer to addr is worth trying: not if the
+// receiver said in the hello exchange that it does not offer them
+func (c *Client) mayParallel(addr string) bool {
+	agreement, ok := c.agreements.Load(addr)
+	return !ok || agreement.(protocol.Agreement).Supports(protocol.FEATURE_PARALLEL)
+}
+
+// sendParallel sends file in parts over up to c.streams connections. The first connection announces
+// the file and the stream count; the receiver answers with the number of streams it allows and
+// confirms the reassembled file on that connection once every part arrived.
+func (c *Client) sendParallel(ctx context.Context, log *slog.Logger, addr string, file *os.File, size int64, name string, started time.Time) (*Result, error) {
+	fail := func(kind, err error) (*Result, error) {
+		return nil, c.wrapError(ctx, log, "send file", addr, kind, err)
+	}
+
+	header, err := crypto.Encrypt([]byte(fmt.Sprintf("%s\n%d\n%d", name, size, c.streams)), []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	s, err := c.open(ctx, log, addr)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	defer s.close()
+	if err := s.writeLine(protocol.PARALLEL_PREFIX + header); err != nil {
+		return fail(ErrConnect, err)
+	}
+
+	log.Debug("waiting for approval", "bytes", size, "streams", c.streams)
+	if err := s.readAccept(); err != nil {
+		// Receivers without parallel transfers take the file over one connection
+		var reject *protocol.RejectError
+		if errors.As(err, &reject) && reject.Code == protocol.REJECT_UNSUPPORTED {
+			return nil, errParallelUnsupported
+		}
+		return fail(classify(err), err)
+	}
+	line, err := protocol.ReadLine(s.reader, MAX_REPLY_LINE)
+	if err != nil {
+		return fail(ErrConnect, err)
+	}
+	reply, err := crypto.Decrypt(line, []byte(c.key))
+	if err != nil {
+		return fail(ErrProtocol, err)
+	}
+	id, streamsStr, _ := strings.Cut(reply, "\n")
+	streams, err := strconv.Atoi(streamsStr)
+	if err != nil || streams < 1 || streams > c.streams {
+		return fail(ErrProtocol, fmt.Errorf("invalid stream count %q", streamsStr))
+	}
+	log.Debug("transfer accepted", "streams", streams)
+
+	var shared *progress.Shared
+	if c.progress != nil {
+		shared = progress.NewShared(c.progress(name, size))
+		defer shared.Finish()
+	}
+
+	// Hash the whole file for the final confirmation while the parts are sent
+	var fullSum string
+	var fullErr error
+	hashed := make(chan struct{})
+	go func() {
+		defer close(hashed)
+		hash := sha256.New()
+		if _, fullErr = io.CopyBuffer(hash, io.NewSectionReader(file, 0, size), make([]byte, BUFFER_SIZE)); fullErr == nil {
+			fullSum = hex.EncodeToString(hash.Sum(nil))
+		}
+	}()
+
+	// The first failing part stops the others
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	var partErr error
+	var wg sync.WaitGroup
+	for i, part := range protocol.SplitParts(size, streams) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reporter progress.Reporter
+			if shared != nil {
+				reporter = shared.Part()
+			}
+			if err := c.sendPart(partCtx, log.With("part", i), addr, file, id, i, part, reporter); err != nil {
+				once.Do(func() {
+					partErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	<-hashed
+	if partErr != nil {
+		return nil, partErr
+	}
+	if fullErr != nil {
+		return fail(ErrLocalIO, fullErr)
+	}
+
+	// The receiver verifies the reassembled file before it confirms
+	s.conn.waiting = true
+	storedName, checksum, err := s.readDone(c.key)
+	s.conn.waiting = false
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	if checksum != fullSum {
+		return fail(ErrChecksum, fmt.Errorf("receiver stored %s", checksum))
+	}
+
+	duration := time.Since(started)
+	log.Debug("file sent", "stored_as", storedName, "bytes", size, "streams", streams, "duration", duration, "sha256", checksum)
+	return &Result{
+		Addr:       addr,
+		Name:       name,
+		StoredName: storedName,
+		Bytes:      size,
+		Checksum:   checksum,
+		Streams:    streams,
+		Duration:   duration,
+	}, nil
+}
+
+// sendPart sends one part of a parallel transfer on its own connection
+func (c *Client) sendPart(ctx context.Context, log *slog.Logger, addr string, file *os.File, id string, index int, part protocol.Part, reporter progress.Reporter) error {
+	fail := func(kind, err error) error {
+		return c.wrapError(ctx, log, "send file", addr, kind, err)
+	}
+
+	header, err := crypto.Encrypt([]byte(id+"\n"+strconv.Itoa(index)), []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+
+	s, err := c.open(ctx, log, addr)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	defer s.close()
+	if err := s.writeLine(protocol.RANGE_PREFIX + header); err != nil {
+		return fail(ErrConnect, err)
+	}
+	if err := s.writeLine(fmt.Sprintf("%d", crypto.EncryptedSize(part.Length))); err != nil {
+		return fail(ErrConnect, err)
+	}
+	if err := s.readAccept(); err != nil {
+		return fail(classify(err), err)
+	}
+
+	hash := sha256.New()
+	source := &fileReader{r: io.TeeReader(io.NewSectionReader(file, part.Offset, part.Length), hash)}
+	var content io.Reader = source
+	if reporter != nil {
+		content = &progress.Reader{R: content, Reporter: reporter}
+	}
+	encrypter, err := crypto.NewEncryptWriter(s.writer, []byte(c.key))
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	written, err := io.CopyBuffer(encrypter, content, make([]byte, BUFFER_SIZE))
+	if source.err != nil {
+		return fail(ErrLocalIO, source.err)
+	}
+	if err != nil {
+		return fail(ErrConnect, err)
+	}
+	if written != part.Length {
+		return fail(ErrLocalIO, fmt.Errorf("%s changed size while it was sent", file.Name()))
+	}
+	if err := encrypter.Close(); err != nil {
+		return fail(ErrConnect, err)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fail(ErrConnect, err)
+	}
+
+	_, checksum, err := s.readDone(c.key)
+	if err != nil {
+		return fail(classify(err), err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); checksum != sum {
+		return fail(ErrChecksum, fmt.Errorf("receiver stored part %d as %s", index, checksum))
+	}
+	log.Debug("part sent", "bytes", written, "sha256", checksum)
+	return nil
+}
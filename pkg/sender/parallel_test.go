@@ -0,0 +1,163 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"local-share/pkg/crypto"
+	"local-share/pkg/protocol"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+)
+
+var testKey = crypto.PadKey("Str0ng-Passw0rd!")
+
+// writeRandomFile creates name with size random bytes and returns its path and content
+func writeRandomFile(t *testing.T, name string, size int64) (string, []byte) {
+	t.Helper()
+	content := make([]byte, size)
+	random := rand.NewChaCha8([32]byte{byte(size), byte(size >> 8), byte(size >> 16)})
+	random.Read(content)
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, content
+}
+
+func TestSendParallelReassembles(t *testing.T) {
+	server := receivertest.Start(t, testKey)
+	tests := []struct {
+		size        int64
+		streams     int
+		wantStreams int
+	}{
+		{MIN_PARALLEL_SIZE - 1, 4, 1}, // Too small to split
+		{MIN_PARALLEL_SIZE, 4, 4},
+		{MIN_PARALLEL_SIZE + 1, 3, 3}, // Parts of unequal length
+		{MIN_PARALLEL_SIZE + 5, 8, 4}, // The receiver wants parts of at least MIN_PART_SIZE
+		{5*receiver.MIN_PART_SIZE - 1, 8, 4},
+		{9*receiver.MIN_PART_SIZE + 7, 16, receiver.DEFAULT_MAX_STREAMS},
+	}
+	for _, test := range tests {
+		path, content := writeRandomFile(t, "file.bin", test.size)
+		client := NewClient(WithKey(testKey), WithStreams(test.streams))
+		result, err := client.SendFile(context.Background(), server.Addr, path)
+		if err != nil {
+			t.Fatalf("sending %d bytes over %d streams: %v", test.size, test.streams, err)
+		}
+		if result.Streams != test.wantStreams {
+			t.Errorf("%d bytes over %d streams used %d, want %d", test.size, test.streams, result.Streams, test.wantStreams)
+		}
+		stored, err := os.ReadFile(filepath.Join(server.Dir, result.StoredName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stored, content) {
+			t.Errorf("%d bytes over %d streams arrived changed", test.size, test.streams)
+		}
+	}
+}
+
+// countingApprover approves everything and counts how often it was asked
+type countingApprover struct {
+	asked atomic.Int32
+}
+
+func (a *countingApprover) Approve(req receiver.TransferRequest) bool {
+	a.asked.Add(1)
+	return true
+}
+
+func TestSendParallelFallsBack(t *testing.T) {
+	approver := &countingApprover{}
+	server := receivertest.Start(t, testKey, receiver.WithLimits(receiver.Limits{MaxStreams: 1}), receiver.WithApprover(approver))
+	path, content := writeRandomFile(t, "file.bin", MIN_PARALLEL_SIZE)
+
+	client := NewClient(WithKey(testKey), WithStreams(4))
+	result, err := client.SendFile(context.Background(), server.Addr, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Streams != 1 {
+		t.Errorf("sent over %d streams, want 1", result.Streams)
+	}
+	if stored, err := os.ReadFile(filepath.Join(server.Dir, result.StoredName)); err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("file arrived changed (%v)", err)
+	}
+	if asked := approver.asked.Load(); asked != 1 {
+		t.Errorf("receiver asked %d times, want once", asked)
+	}
+}
+
+// sequentialStorage hides that its storage can write at offsets
+type sequentialStorage struct {
+	receiver.Storage
+}
+
+func TestSendParallelToSequentialStorage(t *testing.T) {
+	approver := &countingApprover{}
+	storage, err := receiver.NewDirStorage(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := receivertest.Start(t, testKey, receiver.WithStorage(sequentialStorage{storage}), receiver.WithApprover(approver))
+	path, content := writeRandomFile(t, "file.bin", MIN_PARALLEL_SIZE)
+
+	client := NewClient(WithKey(testKey), WithStreams(4))
+	result, err := client.SendFile(context.Background(), server.Addr, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Streams != 1 {
+		t.Errorf("sent over %d streams, want 1", result.Streams)
+	}
+	if stored, err := os.ReadFile(filepath.Join(storage.Dir(), result.StoredName)); err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("file arrived changed (%v)", err)
+	}
+	if asked := approver.asked.Load(); asked != 1 {
+		t.Errorf("receiver asked %d times, want once", asked)
+	}
+}
+
+func TestSendParallelConnectionLimit(t *testing.T) {
+	path, content := writeRandomFile(t, "file.bin", 8*receiver.MIN_PART_SIZE)
+	// Every part takes a connection besides the one announcing the transfer
+	for maxConnections, wantStreams := range map[int]int{2: 1, 3: 2, 5: 4} {
+		server := receivertest.Start(t, testKey, receiver.WithLimits(receiver.Limits{MaxConnections: maxConnections}))
+		client := NewClient(WithKey(testKey), WithStreams(8))
+		result, err := client.SendFile(context.Background(), server.Addr, path)
+		if err != nil {
+			t.Fatalf("sending with %d connections allowed: %v", maxConnections, err)
+		}
+		if result.Streams != wantStreams {
+			t.Errorf("%d connections allowed, sent over %d streams, want %d", maxConnections, result.Streams, wantStreams)
+		}
+		if stored, err := os.ReadFile(filepath.Join(server.Dir, result.StoredName)); err != nil || !bytes.Equal(stored, content) {
+			t.Errorf("%d connections allowed, file arrived changed (%v)", maxConnections, err)
+		}
+	}
+}
+
+func TestSendParallelRejectedIsNotRetried(t *testing.T) {
+	approver := &countingApprover{}
+	addr := receivertest.Start(t, testKey, receiver.WithApprover(approver)).Addr
+	// The receiver refuses this name as invalid after approving it
+	path, _ := writeRandomFile(t, receiver.TEMP_FILE_PREFIX+"x"+receiver.TEMP_FILE_SUFFIX, MIN_PARALLEL_SIZE)
+
+	client := NewClient(WithKey(testKey), WithStreams(4))
+	_, err := client.SendFile(context.Background(), addr, path)
+	var reject *protocol.RejectError
+	if !errors.Is(err, ErrRejected) || !errors.As(err, &reject) || reject.Code != protocol.REJECT_INVALID {
+		t.Fatalf("SendFile = %v, want an invalid rejection", err)
+	}
+	if asked := approver.asked.Load(); asked != 1 {
+		t.Errorf("receiver asked %d times, want once", asked)
+	}
+}
@@ -1,133 +1,322 @@
 package sender
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"local-share/pkg/crypto"
+	"local-share/pkg/output"
+	"local-share/pkg/progress"
+	"local-share/pkg/protocol"
 )
 
 const (
 	PORT = ":8080"
 )
 
-// SendText sends encrypted text to a server
-func SendText(serverIP, message string) {
-	// Get the encryption key
-	key, err := crypto.GetEncryptionKey(false)
-	if err != nil {
-		fmt.Printf("Error getting encryption key: %v\n", err)
-		return
+// Config holds the sender settings chosen on the command line
+type Config struct {
+	Port               int             // Receiver port used when the address has none, 8080 if 0
+	KeySource          string          // Where the password comes from (see crypto.GetEncryptionKeyFrom)
+	InsecureNoPassword bool            // Use the well-known insecure key instead of a password
+	Fingerprint        string          // Expected key fingerprint (see crypto.Fingerprint), empty to skip the check
+	Streams            int             // Connections a large file may be split over, 1 if 0
+	RateLimit          int64           // Bytes per second, 0 for no limit
+	DialTimeout        time.Duration   // How long connecting may take, DEFAULT_DIAL_TIMEOUT if 0
+	Retries            int             // How often connecting is retried
+	Wait               time.Duration   // How long to keep trying to connect to a receiver that is not up yet
+	Logger             *slog.Logger    // Diagnostics, nil to discard them
+	Output             *output.Printer // Where results are printed, nil for human readable stdout
+}
+
+// options returns the client options shared by the command line senders
+func (config Config) options(key string) []Option {
+	options := []Option{WithKey(key)}
+	if config.Logger != nil {
+		options = append(options, WithLogger(config.Logger))
+	}
+	if config.Streams > 1 {
+		options = append(options, WithStreams(config.Streams))
+	}
+	if config.RateLimit > 0 {
+		options = append(options, WithRateLimit(config.RateLimit))
+	}
+	if config.DialTimeout > 0 {
+		options = append(options, WithDialTimeout(config.DialTimeout))
 	}
+	if config.Retries > 0 || config.Wait > 0 {
+		options = append(options, WithRetries(config.Retries), WithWait(config.Wait))
+	}
+	return options
+}
 
-	conn, err := net.Dial("tcp", serverIP+PORT)
-	if err != nil {
-		fmt.Printf("Error connecting to server: %v\n", err)
-		return
+// address adds the configured port to addr when it has none
+func (config Config) address(addr string) string {
+	if config.Port <= 0 {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
 	}
-	defer conn.Close()
+	return net.JoinHostPort(strings.Trim(addr, "[]"), strconv.Itoa(config.Port))
+}
 
-	// Encrypt the message
-	encryptedMsg, err := crypto.Encrypt([]byte(message), []byte(key))
-	if err != nil {
-		fmt.Printf("Error encrypting message: %v\n", err)
-		return
+// printer returns where results are printed
+func (config Config) printer() *output.Printer {
+	if config.Output == nil {
+		return output.Stdout
 	}
+	return config.Output
+}
 
-	// Send the encrypted message with "TEXT:" prefix
-	writer := bufio.NewWriter(conn)
-	_, err = writer.WriteString("TEXT:" + encryptedMsg + "\n")
+// SendText sends encrypted text to a server from the command line, printing the outcome.
+// The returned error matches one of the Err* kinds with errors.Is.
+func SendText(serverIP, message string, config Config) error {
+	out := config.printer()
+
+	// Get the encryption key
+	key, err := config.key(out)
 	if err != nil {
-		fmt.Printf("Error sending message: %v\n", err)
-		return
+		return err
 	}
 
-	// Ensure the message is sent
-	if err := writer.Flush(); err != nil {
-		fmt.Printf("Error flushing message: %v\n", err)
-		return
+	client := NewClient(config.options(key)...)
+	result, err := client.SendText(context.Background(), config.address(serverIP), message)
+	if err != nil {
+		printError(out, err)
+		return err
 	}
 
-	fmt.Println("Encrypted message sent successfully")
+	out.Event("sent", output.Fields{
+		"type":        "text",
+		"addr":        result.Addr,
+		"bytes":       result.Bytes,
+		"sha256":      result.Checksum,
+		"duration_ms": result.Duration.Milliseconds(),
+	}, "Encrypted message sent successfully\n")
+	return nil
 }
 
-// SendFile sends an encrypted file to a server
-func SendFile(serverIP, filePath string) {
+// SendFile sends an encrypted file to a server from the command line, printing the outcome.
+// The returned error matches one of the Err* kinds with errors.Is.
+func SendFile(serverIP, filePath string, config Config) error {
+	out := config.printer()
+
 	// Get the encryption key
-	key, err := crypto.GetEncryptionKey(false)
+	key, err := config.key(out)
 	if err != nil {
-		fmt.Printf("Error getting encryption key: %v\n", err)
-		return
+		return err
 	}
 
-	// Open the file
-	file, err := os.Open(filePath)
+	out.Event("sending", output.Fields{
+		"name": filepath.Base(filePath),
+		"addr": withDefaultPort(config.address(serverIP)),
+	}, "Sending %s (waiting for the receiver to accept)...\n", filepath.Base(filePath))
+	options := config.options(key)
+	if !out.JSON() {
+		// Progress bars are only drawn when a terminal is watching
+		options = append(options, WithProgress(progress.TerminalFactory(os.Stdout)))
+	}
+	client := NewClient(options...)
+	result, err := client.SendFile(context.Background(), config.address(serverIP), filePath)
 	if err != nil {
-		fmt.Printf("Error opening file: %v\n", err)
-		return
+		printError(out, err)
+		return err
 	}
-	defer file.Close()
 
-	// Connect to server
-	conn, err := net.Dial("tcp", serverIP+PORT)
-	if err != nil {
-		fmt.Printf("Error connecting to server: %v\n", err)
-		return
+	printSent(out, result)
+	return nil
+}
+
+// printSent prints a file that was sent
+func printSent(out *output.Printer, result *Result) {
+	out.Event("sent", output.Fields{
+		"type":        "file",
+		"addr":        result.Addr,
+		"name":        result.Name,
+		"stored_as":   result.StoredName,
+		"bytes":       result.Bytes,
+		"sha256":      result.Checksum,
+		"streams":     result.Streams,
+		"duration_ms": result.Duration.Milliseconds(),
+	}, "File %s encrypted and sent successfully: %s in %s (%s/s%s), stored as %s, sha256 %s\n",
+		result.Name, progress.FormatSize(result.Bytes), result.Duration.Round(time.Millisecond),
+		progress.FormatSize(bytesPerSecond(result.Bytes, result.Duration)), streamsNote(result.Streams), result.StoredName, result.Checksum)
+}
+
+// streamsNote mentions the connections of a parallel transfer
+func streamsNote(streams int) string {
+	if streams <= 1 {
+		return ""
 	}
-	defer conn.Close()
+	return fmt.Sprintf(" over %d connections", streams)
+}
 
-	// Read the entire file
-	fileContent, err := io.ReadAll(file)
+// List prints the entries of the directory dir shared by a server, from the command line.
+// The returned error matches one of the Err* kinds with errors.Is.
+func List(serverIP, dir string, config Config) error {
+	out := config.printer()
+	key, err := config.key(out)
 	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
-		return
+		return err
 	}
 
-	// Encrypt the file content
-	encryptedContent, err := crypto.Encrypt(fileContent, []byte(key))
+	client := NewClient(config.options(key)...)
+	entries, err := client.List(context.Background(), config.address(serverIP), dir)
 	if err != nil {
-		fmt.Printf("Error encrypting file: %v\n", err)
-		return
+		printError(out, err)
+		return err
 	}
 
-	// Encrypt the filename
-	filename := filepath.Base(filePath)
-	encryptedFilename, err := crypto.Encrypt([]byte(filename), []byte(key))
-	if err != nil {
-		fmt.Printf("Error encrypting filename: %v\n", err)
-		return
+	if len(entries) == 0 {
+		out.Message("No files shared here")
+		return nil
+	}
+	for _, entry := range entries {
+		size, name := progress.FormatSize(entry.Size), entry.Name
+		if entry.Dir {
+			size, name = "-", name+"/"
+		}
+		out.Event("entry", output.Fields{
+			"name":  entry.Name,
+			"bytes": entry.Size,
+			"dir":   entry.Dir,
+			"mtime": entry.ModTime.Format(time.RFC3339),
+		}, "%10s  %s  %s\n", size, entry.ModTime.Local().Format("2006-01-02 15:04"), name)
 	}
+	return nil
+}
 
-	writer := bufio.NewWriter(conn)
+// Get downloads the file name shared by a server to destPath, from the command line.
+// An existing directory as destPath receives the file under its own name, and an
+// existing file is only replaced with overwrite. The returned error matches one of
+// the Err* kinds with errors.Is.
+func Get(serverIP, name, destPath string, overwrite bool, config Config) error {
+	out := config.printer()
+	if destPath == "" {
+		destPath = path.Base(name)
+	}
+	if info, err := os.Stat(destPath); err == nil && info.IsDir() {
+		destPath = filepath.Join(destPath, path.Base(name))
+	}
+	if _, err := os.Lstat(destPath); err == nil && !overwrite {
+		err := &Error{Op: "get", Addr: serverIP, Kind: ErrLocalIO, Err: fmt.Errorf("%s already exists (use --force to replace it)", destPath)}
+		printError(out, err)
+		return err
+	}
 
-	// Send the encrypted filename
-	_, err = writer.WriteString("FILE:" + encryptedFilename + "\n")
+	key, err := config.key(out)
 	if err != nil {
-		fmt.Printf("Error sending filename: %v\n", err)
-		return
+		return err
 	}
-	writer.Flush()
-
-	// Send the encrypted content length followed by content
-	contentLength := len(encryptedContent)
-	_, err = writer.WriteString(fmt.Sprintf("%d\n", contentLength))
+	options := config.options(key)
+	if !out.JSON() {
+		options = append(options, WithProgress(progress.TerminalFactory(os.Stdout)))
+	}
+	client := NewClient(options...)
+	result, err := client.Get(context.Background(), config.address(serverIP), name, destPath)
 	if err != nil {
-		fmt.Printf("Error sending content length: %v\n", err)
-		return
+		printError(out, err)
+		return err
 	}
-	writer.Flush()
 
-	// Send the encrypted content
-	_, err = writer.WriteString(encryptedContent)
+	out.Event("received", output.Fields{
+		"addr":        result.Addr,
+		"name":        result.Name,
+		"path":        result.StoredName,
+		"bytes":       result.Bytes,
+		"sha256":      result.Checksum,
+		"duration_ms": result.Duration.Milliseconds(),
+	}, "File %s downloaded and decrypted: %s in %s (%s/s), saved as %s, sha256 %s\n",
+		result.Name, progress.FormatSize(result.Bytes), result.Duration.Round(time.Millisecond),
+		progress.FormatSize(bytesPerSecond(result.Bytes, result.Duration)), result.StoredName, result.Checksum)
+	return nil
+}
+
+// key returns the encryption key, checking it against the expected fingerprint
+func (config Config) key(out *output.Printer) (string, error) {
+	key, err := getKey(out, config.KeySource, config.InsecureNoPassword)
 	if err != nil {
-		fmt.Printf("Error sending file content: %v\n", err)
-		return
+		out.Error("Error getting encryption key", err)
+		return "", fmt.Errorf("%w: %w", ErrNoKey, err)
+	}
+	if err := config.checkFingerprint(key); err != nil {
+		printError(out, err)
+		return "", err
 	}
-	writer.Flush()
+	return key, nil
+}
+
+// checkFingerprint compares the fingerprint of key with the expected one, if any
+func (config Config) checkFingerprint(key string) error {
+	if config.Fingerprint == "" {
+		return nil
+	}
+	if got := crypto.Fingerprint(key); crypto.NormalizeFingerprint(got) != crypto.NormalizeFingerprint(config.Fingerprint) {
+		return fmt.Errorf("%w: key fingerprint %s does not match the expected %s", ErrAuth, got, config.Fingerprint)
+	}
+	return nil
+}
 
-	fmt.Printf("File %s encrypted and sent successfully\n", filename)
-} 
\ No newline at end of file
+// getKey returns the encryption key, warning when the insecure key is used
+func getKey(out *output.Printer, keySource string, insecureNoPassword bool) (string, error) {
+	if insecureNoPassword {
+		out.Warning("sending with --insecure-no-password, the transfer is not confidential")
+	}
+	return crypto.GetEncryptionKeyFrom(keySource, false, insecureNoPassword)
+}
+
+// errorKinds names the error kinds in JSON output
+var errorKinds = []struct {
+	kind error
+	name string
+}{
+	{ErrNoKey, "no_key"},
+	{ErrConnect, "connect"},
+	{ErrAuth, "auth"},
+	{ErrRejected, "rejected"},
+	{ErrProtocol, "protocol"},
+	{ErrChecksum, "checksum"},
+	{ErrLocalIO, "local_io"},
+	{ErrIncompatible, "incompatible"},
+	{context.Canceled, "canceled"},
+	{context.DeadlineExceeded, "timeout"},
+}
+
+// printError prints a failed transfer with its kind and, if rejected, the receiver's reject code
+func printError(out *output.Printer, err error) {
+	out.Event("error", errorFields(err), "Error: %v\n", err)
+}
+
+// errorFields returns the JSON fields describing a failed transfer
+func errorFields(err error) output.Fields {
+	fields := output.Fields{"error": err.Error()}
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			fields["kind"] = k.name
+			break
+		}
+	}
+	var reject *protocol.RejectError
+	if errors.As(err, &reject) && reject.Code != "" {
+		fields["reject_code"] = reject.Code
+	}
+	return fields
+}
+
+// bytesPerSecond returns the average transfer speed
+func bytesPerSecond(bytes int64, duration time.Duration) int64 {
+	if duration <= 0 {
+		return bytes
+	}
+	return int64(float64(bytes) / duration.Seconds())
+}
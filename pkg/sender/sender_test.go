@@ -0,0 +1,75 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"local-share/pkg/output"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+)
+
+// events returns the names of the JSON events printed to out
+func events(t *testing.T, out *bytes.Buffer) []string {
+	t.Helper()
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event struct{ Event string }
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		names = append(names, event.Event)
+	}
+	return names
+}
+
+func TestCommandsPrintEvents(t *testing.T) {
+	shared := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(shared, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	share, err := receiver.NewDirShare(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := receivertest.Start(t, testKey, receiver.WithShare(share))
+	file := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(file, []byte("quarterly numbers"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOCAL_SHARE_TEST_KEY", "Str0ng-Passw0rd!")
+
+	// Nothing goes around the printer in JSON mode
+	var printed bytes.Buffer
+	config := Config{KeySource: "env:LOCAL_SHARE_TEST_KEY", Output: output.New(&printed, true)}
+	if err := SendFile(server.Addr, file, config); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := events(t, &printed), []string{"sending", "sent"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("send file printed %v, want %v", got, want)
+	}
+
+	printed.Reset()
+	if err := List(server.Addr, "", config); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := events(t, &printed), []string{"entry", "entry"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ls printed %v, want %v", got, want)
+	}
+
+	printed.Reset()
+	config.Output = output.New(&printed, false)
+	if err := List(server.Addr, "", config); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(printed.String()), "\n"); len(lines) != 2 || !strings.HasSuffix(lines[0], "  a.txt") {
+		t.Errorf("ls printed %q, want a line per file", printed.String())
+	}
+}
@@ -0,0 +1,230 @@
+package sender
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"local-share/pkg/output"
+	"local-share/pkg/progress"
+	"local-share/pkg/protocol"
+)
+
+// SyncOptions controls a folder synchronization
+type SyncOptions struct {
+	Target string // Folder on the receiver, below its upload directory; the name of the local folder if empty
+	Delete bool   // Remove files from the receiver that the local folder no longer has
+	DryRun bool   // Only print what would be transferred and removed
+}
+
+// SyncPlan is what a synchronization transfers and removes. Names are relative to the folder.
+type SyncPlan struct {
+	Upload    []protocol.Entry // New or changed files
+	Delete    []protocol.Entry // Files only the receiver has, empty unless deleting
+	Unchanged int              // Files the receiver already has
+}
+
+// LocalManifest returns the regular files below dir with their checksums.
+// Symbolic links are not followed.
+func LocalManifest(dir string) ([]protocol.Entry, error) {
+	entries := []protocol.Entry{}
+	err := filepath.WalkDir(dir, func(local string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		checksum, err := fileChecksum(local)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, local)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, protocol.Entry{
+			Name:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			SHA256:  checksum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// PlanSync compares the local files with the receiver's manifest. Files differing in size
+// or checksum are uploaded; with remove, files only the receiver has are deleted.
+func PlanSync(local, remote []protocol.Entry, remove bool) SyncPlan {
+	stored := make(map[string]protocol.Entry, len(remote))
+	for _, entry := range remote {
+		stored[entry.Name] = entry
+	}
+
+	var plan SyncPlan
+	for _, entry := range local {
+		if have, ok := stored[entry.Name]; ok && have.Size == entry.Size && have.SHA256 == entry.SHA256 {
+			plan.Unchanged++
+		} else {
+			plan.Upload = append(plan.Upload, entry)
+		}
+		delete(stored, entry.Name)
+	}
+	if remove {
+		for _, entry := range remote {
+			if _, ok := stored[entry.Name]; ok {
+				plan.Delete = append(plan.Delete, entry)
+			}
+		}
+	}
+	return plan
+}
+
+// Sync makes the folder Target on a server match the local folder dir, from the command line,
+// printing every transferred and removed file. The returned error matches one of the Err*
+// kinds with errors.Is.
+func Sync(serverIP, dir string, options SyncOptions, config Config) error {
+	out := config.printer()
+	addr := config.address(serverIP)
+	fail := func(kind, err error) error {
+		err = &Error{Op: "sync", Addr: withDefaultPort(addr), Kind: kind, Err: err}
+		printError(out, err)
+		return err
+	}
+
+	target := options.Target
+	if target == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fail(ErrLocalIO, err)
+		}
+		target = filepath.Base(abs)
+	}
+	target = filepath.ToSlash(target)
+	if slices.Contains(strings.Split(target, "/"), "..") {
+		return fail(ErrLocalIO, fmt.Errorf("invalid sync target %q", options.Target))
+	}
+	target = strings.Trim(path.Clean("/"+target), "/")
+	if target == "" {
+		return fail(ErrLocalIO, fmt.Errorf("invalid sync target %q", options.Target))
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fail(ErrLocalIO, fmt.Errorf("%s is not a directory", dir))
+	}
+
+	key, err := config.key(out)
+	if err != nil {
+		return err
+	}
+	clientOptions := config.options(key)
+	if !out.JSON() {
+		// Progress bars are only drawn when a terminal is watching
+		clientOptions = append(clientOptions, WithProgress(progress.TerminalFactory(os.Stdout)))
+	}
+	client := NewClient(clientOptions...)
+	ctx := context.Background()
+	started := time.Now()
+
+	local, err := LocalManifest(dir)
+	if err != nil {
+		return fail(ErrLocalIO, err)
+	}
+	remote, err := client.Manifest(ctx, addr, target)
+	if err != nil {
+		printError(out, err)
+		return err
+	}
+	plan := PlanSync(local, remote, options.Delete)
+
+	// Remove files first so a directory can take the place of a deleted file
+	var uploaded, deleted, bytes int64
+	for _, entry := range plan.Delete {
+		name := path.Join(target, entry.Name)
+		if !options.DryRun {
+			if err := client.Delete(ctx, addr, name); err != nil {
+				printError(out, err)
+				return err
+			}
+		}
+		deleted++
+		out.Event("synced", output.Fields{
+			"action":  "delete",
+			"name":    name,
+			"bytes":   entry.Size,
+			"dry_run": options.DryRun,
+		}, "  - %s\n", name)
+	}
+	for _, entry := range plan.Upload {
+		name := path.Join(target, entry.Name)
+		if !options.DryRun {
+			result, err := client.SyncFile(ctx, addr, filepath.Join(dir, filepath.FromSlash(entry.Name)), name)
+			if err != nil {
+				printError(out, err)
+				return err
+			}
+			entry.Size = result.Bytes
+		}
+		uploaded++
+		bytes += entry.Size
+		out.Event("synced", output.Fields{
+			"action":  "upload",
+			"name":    name,
+			"bytes":   entry.Size,
+			"sha256":  entry.SHA256,
+			"dry_run": options.DryRun,
+		}, "  + %s (%s)\n", name, progress.FormatSize(entry.Size))
+	}
+
+	duration := time.Since(started)
+	summary := "Synced"
+	if options.DryRun {
+		summary = "Dry run, nothing changed. Would sync"
+	}
+	out.Event("sync", output.Fields{
+		"addr":        withDefaultPort(addr),
+		"target":      target,
+		"uploaded":    uploaded,
+		"deleted":     deleted,
+		"unchanged":   plan.Unchanged,
+		"bytes":       bytes,
+		"dry_run":     options.DryRun,
+		"duration_ms": duration.Milliseconds(),
+	}, "%s %s to %s: %d uploaded (%s), %d deleted, %d unchanged in %s\n",
+		summary, dir, target, uploaded, progress.FormatSize(bytes), deleted, plan.Unchanged, duration.Round(time.Millisecond))
+	return nil
+}
+
+// fileChecksum returns the SHA-256 of the file at local, hex encoded
+func fileChecksum(local string) (string, error) {
+	file, err := os.Open(local)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.CopyBuffer(hash, file, make([]byte, BUFFER_SIZE)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
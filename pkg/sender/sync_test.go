@@ -0,0 +1,91 @@
+package sender
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"local-share/pkg/protocol"
+)
+
+func TestPlanSync(t *testing.T) {
+	entry := func(name string, size int64, sum string) protocol.Entry {
+		return protocol.Entry{Name: name, Size: size, SHA256: sum}
+	}
+	names := func(entries []protocol.Entry) []string {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name          string
+		local, remote []protocol.Entry
+		remove        bool
+		wantUpload    []string
+		wantDelete    []string
+		wantUnchanged int
+	}{
+		{"nothing", nil, nil, true, nil, nil, 0},
+		{"new files", []protocol.Entry{entry("a", 1, "1"), entry("b/c", 2, "2")}, nil, false, []string{"a", "b/c"}, nil, 0},
+		{"unchanged", []protocol.Entry{entry("a", 1, "1")}, []protocol.Entry{entry("a", 1, "1")}, true, nil, nil, 1},
+		{"changed content", []protocol.Entry{entry("a", 1, "new")}, []protocol.Entry{entry("a", 1, "old")}, false, []string{"a"}, nil, 0},
+		{"changed size", []protocol.Entry{entry("a", 2, "1")}, []protocol.Entry{entry("a", 1, "1")}, false, []string{"a"}, nil, 0},
+		{"deleted without --delete", nil, []protocol.Entry{entry("gone", 1, "1")}, false, nil, nil, 0},
+		{"deleted with --delete", nil, []protocol.Entry{entry("gone", 1, "1")}, true, nil, []string{"gone"}, 0},
+		{"names are compared exactly", []protocol.Entry{entry("A", 1, "1")}, []protocol.Entry{entry("a", 1, "1")}, true, []string{"A"}, []string{"a"}, 0},
+		{"all at once",
+			[]protocol.Entry{entry("new", 1, "1"), entry("same", 2, "2"), entry("changed", 3, "x"), entry("dir/same", 4, "4")},
+			[]protocol.Entry{entry("changed", 3, "3"), entry("dir/gone", 5, "5"), entry("dir/same", 4, "4"), entry("gone", 6, "6"), entry("same", 2, "2")},
+			true, []string{"new", "changed"}, []string{"dir/gone", "gone"}, 2},
+		{"all at once without --delete",
+			[]protocol.Entry{entry("new", 1, "1"), entry("same", 2, "2")},
+			[]protocol.Entry{entry("gone", 6, "6"), entry("same", 2, "2")},
+			false, []string{"new"}, nil, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := PlanSync(test.local, test.remote, test.remove)
+			if got := names(plan.Upload); !reflect.DeepEqual(got, test.wantUpload) {
+				t.Errorf("upload %q, want %q", got, test.wantUpload)
+			}
+			if got := names(plan.Delete); !reflect.DeepEqual(got, test.wantDelete) {
+				t.Errorf("delete %q, want %q", got, test.wantDelete)
+			}
+			if plan.Unchanged != test.wantUnchanged {
+				t.Errorf("%d unchanged, want %d", plan.Unchanged, test.wantUnchanged)
+			}
+		})
+	}
+}
+
+func TestLocalManifest(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"index.html": "index", "css/main.css": "css"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("index.html", filepath.Join(dir, "home.html")); err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+
+	entries, err := LocalManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Names use "/" like the receiver's manifest, links are left out
+	if len(entries) != 2 || entries[0].Name != "css/main.css" || entries[1].Name != "index.html" {
+		t.Fatalf("manifest = %+v", entries)
+	}
+	if entries[1].Size != 5 || entries[1].SHA256 != "1bc04b5291c26a46d918139138b992d2de976d6851d0893b0476b85bfbdfc6e6" {
+		t.Errorf("manifest entry = %+v", entries[1])
+	}
+}
@@ -0,0 +1,133 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"local-share/pkg/output"
+	"local-share/pkg/progress"
+	"local-share/pkg/watch"
+)
+
+// WatchOptions controls which files Watch sends and when
+type WatchOptions struct {
+	Ignore   []string      // Glob patterns of filenames not to send, in addition to watch.DEFAULT_IGNORE
+	Debounce time.Duration // Wait until a file did not change for this long, watch.DEFAULT_DEBOUNCE if 0
+	Poll     time.Duration // Poll the folder this often instead of using file notifications, 0 for notifications
+}
+
+// Watch sends every file created or changed in dir to a server, from the command line,
+// until interrupted. Failed transfers are printed and watching goes on. The returned error
+// matches one of the Err* kinds with errors.Is.
+func Watch(serverIP, dir string, options WatchOptions, config Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return watchDir(ctx, serverIP, dir, options, config)
+}
+
+// watchDir is Watch until ctx ends
+func watchDir(ctx context.Context, serverIP, dir string, options WatchOptions, config Config) error {
+	out := config.printer()
+	addr := config.address(serverIP)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		err := &Error{Op: "watch", Addr: withDefaultPort(addr), Kind: ErrLocalIO, Err: fmt.Errorf("%s is not a directory", dir)}
+		printError(out, err)
+		return err
+	}
+	debounce := options.Debounce
+	if debounce <= 0 {
+		debounce = watch.DEFAULT_DEBOUNCE
+	}
+	ignore := append(append([]string{}, watch.DEFAULT_IGNORE...), options.Ignore...)
+
+	// Ask for the password once, before anything changes
+	key, err := config.key(out)
+	if err != nil {
+		return err
+	}
+	clientOptions := config.options(key)
+	if !out.JSON() {
+		// Progress bars are only drawn when a terminal is watching
+		clientOptions = append(clientOptions, WithProgress(progress.TerminalFactory(os.Stdout)))
+	}
+	client := NewClient(clientOptions...)
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// Send one file at a time, in the order they settled
+	queue := make(chan string, 64)
+	debouncer := watch.NewDebouncer(debounce, func(name string) {
+		select {
+		case queue <- name:
+		case <-ctx.Done():
+		}
+	})
+	defer debouncer.Stop()
+
+	var sent, failed int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case name := <-queue:
+				path := filepath.Join(dir, name)
+				// Files deleted or replaced by a directory in the meantime are skipped
+				if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+					continue
+				}
+				result, err := client.SendFile(ctx, addr, path)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					failed++
+					printError(out, err)
+					continue
+				}
+				sent++
+				printSent(out, result)
+			}
+		}
+	}()
+
+	out.Event("watching", output.Fields{
+		"dir":         dir,
+		"addr":        withDefaultPort(addr),
+		"ignore":      ignore,
+		"debounce_ms": debounce.Milliseconds(),
+	}, "Watching %s, new and changed files are sent to %s (Ctrl+C to stop)\n", dir, withDefaultPort(addr))
+
+	changed := func(name string) {
+		if !watch.Ignored(name, ignore) {
+			debouncer.Trigger(name)
+		}
+	}
+	if options.Poll > 0 {
+		err = watch.Poll(ctx, dir, options.Poll, changed)
+	} else {
+		err = watch.Watch(ctx, dir, changed)
+	}
+	stop()
+	<-done
+
+	out.Event("stopped", output.Fields{
+		"sent":   sent,
+		"failed": failed,
+	}, "Watch stopped: %d file(s) sent, %d failed\n", sent, failed)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		err = &Error{Op: "watch", Addr: withDefaultPort(addr), Kind: ErrLocalIO, Err: err}
+		printError(out, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package sender
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"local-share/pkg/output"
+	"local-share/pkg/receiver"
+	"local-share/pkg/receiver/receivertest"
+)
+
+func TestWatchSendsSettledFiles(t *testing.T) {
+	const debounce = 150 * time.Millisecond
+	var mu sync.Mutex
+	var received []receiver.ReceivedFile
+	server := receivertest.Start(t, testKey, receiver.WithFileHandler(func(file receiver.ReceivedFile) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, file)
+	}))
+	// receivedSizes waits until count files arrived and a little longer for files that should not come
+	receivedSizes := func(count int) []int64 {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			mu.Lock()
+			n := len(received)
+			mu.Unlock()
+			if n >= count {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d file(s) received, want %d", n, count)
+			}
+		}
+		time.Sleep(2 * debounce)
+		mu.Lock()
+		defer mu.Unlock()
+		var sizes []int64
+		for _, file := range received {
+			sizes = append(sizes, file.Size)
+		}
+		return sizes
+	}
+
+	dir := t.TempDir()
+	t.Setenv("LOCAL_SHARE_TEST_KEY", "Str0ng-Passw0rd!")
+	config := Config{KeySource: "env:LOCAL_SHARE_TEST_KEY", Output: output.New(io.Discard, false)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- watchDir(ctx, server.Addr, dir, WatchOptions{Debounce: debounce, Poll: 20 * time.Millisecond}, config)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch = %v, want nil when stopped", err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// A file written in several steps, each shorter than the debounce delay, is sent once, complete
+	file, err := os.Create(filepath.Join(dir, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for stop := time.Now().Add(4 * debounce); time.Now().Before(stop); time.Sleep(debounce / 3) {
+		n, err := file.Write(make([]byte, 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += int64(n)
+		mu.Lock()
+		early := len(received)
+		mu.Unlock()
+		if early != 0 {
+			t.Fatal("file sent while it was still being written")
+		}
+	}
+	file.Close()
+	if sizes := receivedSizes(1); len(sizes) != 1 || sizes[0] != size {
+		t.Fatalf("received sizes %v, want one file of %d bytes", sizes, size)
+	}
+
+	// Ignored files are not sent
+	for _, name := range []string{".hidden", "download.part"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("skip"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sizes := receivedSizes(1); len(sizes) != 1 {
+		t.Errorf("received sizes %v, want ignored files not to be sent", sizes)
+	}
+}
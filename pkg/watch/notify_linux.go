@@ -0,0 +1,63 @@
+//go:build linux
+
+package watch
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// notify watches dir with inotify. Files are reported when they are closed after writing
+// or moved into dir, so a file is not reported while it is still being written.
+func notify(ctx context.Context, dir string, changed func(name string)) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnsupported, err)
+	}
+	// A non-blocking descriptor lets closing the file interrupt a pending read
+	events := os.NewFile(uintptr(fd), "inotify")
+	defer events.Close()
+
+	mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR)
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		if err == unix.ENOSPC {
+			// Out of watches, see /proc/sys/fs/inotify/max_user_watches
+			return fmt.Errorf("%w: %v", errUnsupported, err)
+		}
+		return &os.PathError{Op: "watch", Path: dir, Err: err}
+	}
+	stop := context.AfterFunc(ctx, func() { events.Close() })
+	defer stop()
+
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := events.Read(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// Each event is a struct inotify_event followed by the null-padded name
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			eventMask := binary.NativeEndian.Uint32(buffer[offset+4:])
+			nameLength := int(binary.NativeEndian.Uint32(buffer[offset+12:]))
+			start := offset + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buffer[start:min(start+nameLength, n)]), "\x00")
+			offset = start + nameLength
+
+			switch {
+			case eventMask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+				return fmt.Errorf("%s was removed or moved", dir)
+			case eventMask&unix.IN_ISDIR == 0 && name != "":
+				changed(name)
+			}
+		}
+	}
+}
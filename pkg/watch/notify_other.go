@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watch
+
+import "context"
+
+// notify is only implemented with inotify on Linux; elsewhere Watch polls
+func notify(ctx context.Context, dir string, changed func(name string)) error {
+	return errUnsupported
+}
@@ -0,0 +1,158 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_POLL_INTERVAL = 2 * time.Second
+	DEFAULT_DEBOUNCE      = time.Second
+)
+
+// DEFAULT_IGNORE are names that are never reported: hidden files and the temporary
+// files editors, browsers and downloads leave behind while they are still writing
+var DEFAULT_IGNORE = []string{".*", "*~", "*.tmp", "*.part", "*.crdownload", "*.swp"}
+
+// errUnsupported is returned by notify where the operating system has no file notifications
+var errUnsupported = errors.New("file notifications not supported")
+
+// Watch calls changed with the name of every file in dir that is created, written or moved
+// there, until ctx ends. Subdirectories are not watched. It uses inotify on Linux and polls
+// every DEFAULT_POLL_INTERVAL elsewhere or when notifications are not available.
+// It returns nil when ctx ends and an error when dir can no longer be watched.
+func Watch(ctx context.Context, dir string, changed func(name string)) error {
+	err := notify(ctx, dir, changed)
+	if errors.Is(err, errUnsupported) {
+		return Poll(ctx, dir, DEFAULT_POLL_INTERVAL, changed)
+	}
+	return err
+}
+
+// Poll is Watch by comparing the size and modification time of the files every interval.
+// This also works on network filesystems. A file is reported once it did not change
+// for one interval, so files still being written are not reported early.
+func Poll(ctx context.Context, dir string, interval time.Duration, changed func(name string)) error {
+	previous, err := snapshot(dir)
+	if err != nil {
+		return err
+	}
+	reported := previous
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := snapshot(dir)
+		if err != nil {
+			return err
+		}
+		next := make(map[string]fileState, len(current))
+		for name, state := range current {
+			if state == previous[name] && state != reported[name] {
+				changed(name)
+				next[name] = state
+			} else if last, ok := reported[name]; ok {
+				next[name] = last
+			}
+		}
+		previous, reported = current, next
+	}
+}
+
+// fileState is what Poll compares to notice changes
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot returns the state of the regular files in dir
+func snapshot(dir string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files[entry.Name()] = fileState{size: info.Size(), modTime: info.ModTime()}
+	}
+	return files, nil
+}
+
+// Ignored reports whether name matches one of the glob patterns (see path.Match)
+func Ignored(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePattern checks an ignore pattern
+func ValidatePattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// Debouncer delays a call for a name until no further call for it came for a while,
+// so a file written in several steps is handled once
+type Debouncer struct {
+	delay time.Duration
+	fn    func(name string)
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// NewDebouncer returns a debouncer calling fn, in its own goroutine, delay after the last Trigger for a name
+func NewDebouncer(delay time.Duration, fn func(name string)) *Debouncer {
+	return &Debouncer{delay: delay, fn: fn, timers: make(map[string]*time.Timer)}
+}
+
+// Trigger schedules the call for name, postponing one that is already scheduled
+func (d *Debouncer) Trigger(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if timer, ok := d.timers[name]; ok && timer.Stop() {
+		timer.Reset(d.delay)
+		return
+	}
+	// The mutex keeps the callback from running before timer is set
+	var timer *time.Timer
+	timer = time.AfterFunc(d.delay, func() {
+		d.mu.Lock()
+		if d.timers[name] == timer {
+			delete(d.timers, name)
+		}
+		d.mu.Unlock()
+		d.fn(name)
+	})
+	d.timers[name] = timer
+}
+
+// Stop cancels all scheduled calls
+func (d *Debouncer) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, timer := range d.timers {
+		timer.Stop()
+		delete(d.timers, name)
+	}
+}
@@ -0,0 +1,163 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// calls records the names passed to a callback
+type calls struct {
+	mu    sync.Mutex
+	names []string
+	at    map[string]time.Time // When each name was last called
+}
+
+func (c *calls) add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.at == nil {
+		c.at = make(map[string]time.Time)
+	}
+	c.names = append(c.names, name)
+	c.at[name] = time.Now()
+}
+
+func (c *calls) count(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, called := range c.names {
+		if called == name {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor waits until name was called want times and a little longer for calls that should not come
+func (c *calls) waitFor(t *testing.T, name string, want int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); c.count(name) < want; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s called %d times, want %d", name, c.count(name), want)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := c.count(name); got != want {
+		t.Fatalf("%s called %d times, want %d", name, got, want)
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	var called calls
+	debouncer := NewDebouncer(delay, called.add)
+	defer debouncer.Stop()
+
+	// A name triggered again within the delay is called once, delay after the last trigger
+	var last time.Time
+	for range 5 {
+		last = time.Now()
+		debouncer.Trigger("a")
+		debouncer.Trigger("b")
+		time.Sleep(delay / 5)
+	}
+	called.waitFor(t, "a", 1)
+	called.waitFor(t, "b", 1)
+	called.mu.Lock()
+	waited := called.at["a"].Sub(last)
+	called.mu.Unlock()
+	if waited < delay {
+		t.Errorf("called %v after the last trigger, want at least %v", waited, delay)
+	}
+
+	// Triggered again after the call, it is called again
+	debouncer.Trigger("a")
+	called.waitFor(t, "a", 2)
+
+	// Stop cancels scheduled calls
+	debouncer.Trigger("c")
+	debouncer.Stop()
+	time.Sleep(2 * delay)
+	if n := called.count("c"); n != 0 {
+		t.Errorf("c called %d times after Stop", n)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "existing"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remember the size a file had when it was reported
+	var called calls
+	sizes := make(map[string]int64)
+	changed := func(name string) {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			called.mu.Lock()
+			sizes[name] = info.Size()
+			called.mu.Unlock()
+		}
+		called.add(name)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Poll(ctx, dir, interval, changed) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Poll = %v, want nil after cancel", err)
+		}
+	}()
+	time.Sleep(interval / 2)
+
+	// A new file is reported once
+	if err := os.WriteFile(filepath.Join(dir, "new"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	called.waitFor(t, "new", 1)
+
+	// A file still being written is only reported once it stopped changing
+	file, err := os.Create(filepath.Join(dir, "growing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for stop := time.Now().Add(4 * interval); time.Now().Before(stop); time.Sleep(2 * time.Millisecond) {
+		n, err := file.Write(make([]byte, 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += int64(n)
+		if n := called.count("growing"); n != 0 {
+			t.Fatalf("growing reported %d times while it was written", n)
+		}
+	}
+	file.Close()
+	called.waitFor(t, "growing", 1)
+	called.mu.Lock()
+	reported := sizes["growing"]
+	called.mu.Unlock()
+	if reported != size {
+		t.Errorf("growing reported with %d bytes, want all %d", reported, size)
+	}
+
+	// A changed file is reported again
+	if err := os.WriteFile(filepath.Join(dir, "new"), []byte("changed content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	called.waitFor(t, "new", 2)
+
+	if n := called.count("existing") + called.count("subdir"); n != 0 {
+		t.Errorf("files present at the start or directories reported %d times", n)
+	}
+}